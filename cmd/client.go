@@ -1,25 +1,95 @@
 package cmd
 
 import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/protobuf/proto"
 
 	"github.com/robfig/cron/v3"
+	"github.com/yimiaoxiehou/minio-sync/internal/checkpoint"
+	"github.com/yimiaoxiehou/minio-sync/internal/compression"
+	"github.com/yimiaoxiehou/minio-sync/internal/envelope"
+	idgenerator "github.com/yimiaoxiehou/minio-sync/internal/id_generator"
+	"github.com/yimiaoxiehou/minio-sync/internal/journal"
 	"github.com/yimiaoxiehou/minio-sync/internal/message"
 	"github.com/yimiaoxiehou/minio-sync/internal/minio"
 	"github.com/yimiaoxiehou/minio-sync/internal/protocol"
 	rconn "github.com/yimiaoxiehou/minio-sync/internal/reconnectconn"
+	"github.com/yimiaoxiehou/minio-sync/internal/watermark"
 )
 
-func RunClient(addr string, skipBuckets []string, appendOnly bool) {
+// ClientOptions configures RunClient.
+type ClientOptions struct {
+	Addr            string
+	SkipBuckets     []string
+	AppendOnly      bool
+	Readers         int                  // 全量同步时并发读取对象的 goroutine 数
+	BucketReaders   int                  // 其中同一个 bucket 最多几个
+	RestartFullSync bool                 // 忽略上次没做完的全量同步, 从头开始
+	DiffSync        bool                 // 全量同步只发送目标端没有或者不一样的对象
+	Mirror          *minio.MirrorOptions // 全量同步后删除只有目标端有的对象, 隐含 DiffSync
+	JournalDir      string
+	CatchUpMargin   time.Duration // appendonly 启动时补发 watermark 之前这么久以来修改的对象
+	Window          int           // 最多多少条消息在等待 ack
+	WindowBytes     int           // 等待 ack 的消息最多多少字节
+	Backoff         rconn.Backoff
+	TLS             *tls.Config       // nil 时不加密
+	Key             []byte            // 预共享密钥, 握手时向服务端证明身份
+	Keyring         *envelope.Keyring // 端到端加密消息的密钥, nil 时不加密
+}
 
-	c := rconn.New(addr, time.Second*3, 3, time.Second*10, func(err error) {
-		log.Fatalln(err)
-	})
+func RunClient(opts ClientOptions) {
+	skipBuckets := opts.SkipBuckets
+	keyring = opts.Keyring
+	outstanding = newPendingMessages(opts.Window, opts.WindowBytes)
+	order = newKeyOrder(opts.Backoff)
+	var err error
+	spool, err = journal.Open(opts.JournalDir)
+	logErr(err)
+	seqs, err = idgenerator.Open(filepath.Join(opts.JournalDir, "session"))
+	logErr(err)
+	log.Printf("client session(%s)\n", seqs.Session())
+	fullSync, err = checkpoint.Open(filepath.Join(opts.JournalDir, "fullsync.json"))
+	logErr(err)
+	if opts.RestartFullSync {
+		logErr(fullSync.Reset())
+	}
+	mark, err := watermark.Open(filepath.Join(opts.JournalDir, "watermark"))
+	logErr(err)
+	if n := spool.Len(); n > 0 {
+		log.Printf("journal(%s) 中有 %d 条未同步的消息, 重新发送\n", opts.JournalDir, n)
+	}
+	go spoolRequests()
+
+	c := rconn.New(opts.Addr, time.Second*3, opts.Backoff, opts.TLS, func(conn net.Conn) error {
+		hello, err := protocol.ClientHandshake(conn, opts.Key)
+		if errors.Is(err, protocol.ErrIncompatibleVersion) {
+			// retrying won't help until one side is upgraded
+			log.Fatalln(err)
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("protocol version(%d) capabilities(%b)\n", hello.GetVersion(), hello.GetCapabilities())
+		negotiated.Store(hello)
+		if w := int(hello.GetWindowBytes()); w != 0 {
+			// the server closes connections sending more ahead of the acks
+			outstanding.SetMaxBytes(min(w, opts.WindowBytes))
+		}
+		return nil
+	}, onConnState)
 	defer c.Close()
 	go sendAndRecv(c)
+	go recvAck(c)
 
 	log.Println("同步 IAM 信息")
 	reqBuffer <- minio.ExportIAM()
@@ -28,13 +98,36 @@ func RunClient(addr string, skipBuckets []string, appendOnly bool) {
 		log.Printf("同步 bucket(%s) 信息\n", m.Name)
 		reqBuffer <- m
 	}
-	if !appendOnly {
-		minio.ExportAllObject(reqBuffer)
+	// 先开始监听, 全量同步或者 catch-up 期间的修改由事件同步
+	listening := make(chan struct{})
+	go func() {
+		defer close(listening)
+		minio.ListenMinioBucketEvent(skipBuckets, reqBuffer, captured)
+	}()
+	start := time.Now()
+	if !opts.AppendOnly {
+		export := minio.ExportOptions{Readers: opts.Readers, BucketReaders: opts.BucketReaders, Checkpoint: fullSync, SkipBuckets: skipBuckets}
+		if opts.DiffSync || opts.Mirror != nil {
+			export.Mirror = opts.Mirror
+			export.Inventory = func(bucket, after string) (*message.Inventory, error) {
+				return fetchInventory(c, bucket, after)
+			}
+		}
+		minio.ExportAllObject(reqBuffer, export)
+	} else if since := mark.Time(); !since.IsZero() {
+		minio.CatchUp(reqBuffer, skipBuckets, since.Add(-opts.CatchUpMargin))
+	}
+	// 启动之后的修改由事件同步, 之前的已经发送. 还在 reqBuffer 里的消息
+	// 崩溃时会丢, 先等它们写进 journal
+	flushSpool()
+	if start.After(mark.Time()) {
+		logErr(mark.Save(start))
 	}
+	go advanceWatermark(mark)
 
 	// Schedule a cron job to export IAM and Minio buckets data every 5 minutes
 	cr := cron.New()
-	_, err := cr.AddFunc("@every 2h", func() {
+	_, err = cr.AddFunc("@every 2h", func() {
 		log.Println("同步 IAM 信息")
 		reqBuffer <- minio.ExportIAM()
 		log.Println("同步 bucket 信息")
@@ -45,7 +138,37 @@ func RunClient(addr string, skipBuckets []string, appendOnly bool) {
 	})
 	logErr(err)
 	cr.Start()
-	minio.ListenMinioBucketEvent(skipBuckets, reqBuffer)
+	<-listening
+}
+
+// lastEvent is the time of the latest bucket event captured, in unix nanos.
+var lastEvent atomic.Int64
+
+// captured records that the bucket events until t were captured.
+func captured(t time.Time) {
+	for {
+		last := lastEvent.Load()
+		if t.UnixNano() <= last || lastEvent.CompareAndSwap(last, t.UnixNano()) {
+			return
+		}
+	}
+}
+
+// watermarkInterval is how often the watermark is advanced while bucket
+// events are listened to.
+const watermarkInterval = time.Minute
+
+// advanceWatermark moves the watermark to the latest captured event. It is
+// only started once the full sync or catch-up sent what happened before the
+// listener ran.
+func advanceWatermark(mark *watermark.Watermark) {
+	for range time.Tick(watermarkInterval) {
+		if t := time.Unix(0, lastEvent.Load()); t.After(mark.Time()) {
+			// the messages of the events until t are journaled then
+			flushSpool()
+			logErr(mark.Save(t))
+		}
+	}
 }
 
 func logErr(err error) {
@@ -56,18 +179,472 @@ func logErr(err error) {
 
 var reqBuffer chan (*message.MinioMessage) = make(chan (*message.MinioMessage), 8)
 
+// spool 是 reqBuffer 和发送之间的持久化队列, 消息在服务端 ack 之后才会删除
+var spool *journal.Journal
+
+// maxSpoolBatch bounds the messages journaled together.
+const maxSpoolBatch = 64
+
+// spoolRequests moves captured messages from reqBuffer into the journal. The
+// messages waiting are journaled together, so the readers of the full sync
+// aren't held up by one sync of the disk per object.
+func spoolRequests() {
+	for msg := range reqBuffer {
+		var batch []*message.MinioMessage
+		flush := false
+	collect:
+		for {
+			if msg == flushMarker {
+				flush = true
+				break
+			}
+			batch = append(batch, msg)
+			if len(batch) == maxSpoolBatch {
+				break
+			}
+			select {
+			case msg = <-reqBuffer:
+			default:
+				break collect
+			}
+		}
+		for _, m := range batch {
+			stamp(m)
+		}
+		if len(batch) > 0 {
+			_, err := spool.AppendAll(batch)
+			logErr(err)
+		}
+		if flush {
+			spoolFlushed <- struct{}{}
+		}
+	}
+}
+
+// flushMarker is put into reqBuffer by flushSpool, it is never journaled.
+var flushMarker = &message.MinioMessage{}
+
+var spoolFlushed = make(chan struct{})
+
+// flushSpool returns once every message put into reqBuffer before is
+// journaled.
+func flushSpool() {
+	reqBuffer <- flushMarker
+	<-spoolFlushed
+}
+
+// seqs numbers the messages of this client's session.
+var seqs *idgenerator.IdGenerator
+
+// stamp gives msg the next sequence number of the session. It is stamped once,
+// before the message is journaled, so every resend carries the same number.
+func stamp(msg *message.MinioMessage) {
+	var err error
+	msg.Seq, err = seqs.Get()
+	logErr(err)
+	msg.Session = seqs.Session()
+}
+
+// negotiated is the hello negotiated on the current connection.
+var negotiated atomic.Pointer[message.Hello]
+
+// chunkOverhead is the upper bound of a chunk message's size besides its
+// content, bucket and name, including the encryption envelope.
+const chunkOverhead = 192
+
+// keyring encrypts the messages if the server negotiated CapEncryption.
+var keyring *envelope.Keyring
+
+// maxBodySize returns the largest message the current connection accepts.
+func maxBodySize() int {
+	return int(negotiated.Load().GetMaxBodySize())
+}
+
+// bodySize returns the size of the frame body msg is sent in, including the
+// encryption envelope. Compression is left out, it may not shrink it.
+func bodySize(msg *message.MinioMessage) int {
+	// send sets the floor, up to 11 bytes
+	n := proto.Size(msg) + 11
+	if protocol.Has(negotiated.Load(), protocol.CapEncryption) {
+		n = keyring.SealedSize(n)
+	}
+	return n
+}
+
+// errDisconnected aborts sending a message whose connection broke.
+var errDisconnected = errors.New("connection to server lost")
+
+// disconnects counts the broken connections, an object stream started before
+// the last one is aborted and started again.
+var disconnects atomic.Int64
+
+// onConnState requeues everything not acked yet when the connection broke,
+// it is sent again on the next connection.
+func onConnState(s rconn.State) {
+	log.Printf("server connection %s\n", s)
+	if s == rconn.Disconnected {
+		disconnects.Add(1)
+		spool.Requeue(outstanding.Reset()...)
+	}
+}
+
+// outstanding 记录已发送但还没收到服务端 ack 的消息
+var outstanding *pendingMessages
+
+// ackKey identifies the ack of a message. The messages streaming an object
+// share the seq of their entry, its chunks are told apart by their offset.
+type ackKey struct {
+	seq    uint64
+	typ    message.MessageType
+	offset int64
+}
+
+func ackKeyOf(seq uint64, typ message.MessageType, offset int64) ackKey {
+	if typ != message.MessageType_S3_Object_Put_Chunk {
+		// the offset of other acks is where to resume a stream
+		offset = 0
+	}
+	return ackKey{seq, typ, offset}
+}
+
+type pendingMessage struct {
+	id    uint64 // journal entry id, 0 if the message has no entry
+	msg   *message.MinioMessage
+	size  int // frame size, counted against the window
+	acked chan *message.RespMessage
+}
+
+// pendingMessages is the sliding window of messages in flight. It holds at
+// most maxMsgs messages of maxBytes bytes in total, a single message larger
+// than maxBytes is let through alone. As the server acks a message only once
+// it is applied, the window also bounds what the server has to buffer.
+type pendingMessages struct {
+	lock     sync.Mutex
+	room     *sync.Cond // 窗口有空位时通知
+	msgs     map[ackKey]*pendingMessage
+	bytes    int
+	maxMsgs  int
+	maxBytes int
+}
+
+func newPendingMessages(maxMsgs, maxBytes int) *pendingMessages {
+	p := &pendingMessages{msgs: make(map[ackKey]*pendingMessage), maxMsgs: maxMsgs, maxBytes: maxBytes}
+	p.room = sync.NewCond(&p.lock)
+	return p
+}
+
+// Add puts msg, sent in a frame of size bytes, into the window. It blocks
+// while the window is full.
+func (p *pendingMessages) Add(id uint64, msg *message.MinioMessage, size int) <-chan *message.RespMessage {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for len(p.msgs) > 0 && (len(p.msgs) >= p.maxMsgs || p.bytes+size > p.maxBytes) {
+		p.room.Wait()
+	}
+	m := &pendingMessage{id: id, msg: msg, size: size, acked: make(chan *message.RespMessage, 1)}
+	p.msgs[ackKeyOf(msg.GetSeq(), msg.GetType(), msg.GetOffset())] = m
+	p.bytes += size
+	return m.acked
+}
+
+// SetMaxBytes changes the bytes the window holds, e.g. to what the server of
+// a new connection accepts.
+func (p *pendingMessages) SetMaxBytes(maxBytes int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.maxBytes = maxBytes
+	p.room.Broadcast()
+}
+
+// Remove drops the message acked by resp and returns it, nil if it is unknown.
+func (p *pendingMessages) Remove(resp *message.RespMessage) *pendingMessage {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	k := ackKeyOf(resp.GetSeq(), resp.GetType(), resp.GetOffset())
+	m := p.msgs[k]
+	if m != nil {
+		delete(p.msgs, k)
+		p.bytes -= m.size
+		p.room.Broadcast()
+	}
+	return m
+}
+
+// Reset forgets every message, their acks will never arrive. It returns the
+// journal entries of the messages, waiters of an ack receive nil.
+func (p *pendingMessages) Reset() []uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var ids []uint64
+	for k, m := range p.msgs {
+		if m.id != 0 {
+			ids = append(ids, m.id)
+		}
+		m.acked <- nil
+		delete(p.msgs, k)
+	}
+	p.bytes = 0
+	p.room.Broadcast()
+	return ids
+}
+
+func (p *pendingMessages) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.msgs)
+}
+
 func sendAndRecv(c *rconn.Conn) {
 	for {
-		msg := <-reqBuffer
-		log.Printf("send message seq(%d) type(%s) bucket(%s) name(%s)\n", msg.GetSeq(), msg.GetType().String(), msg.GetBucket(), msg.GetName())
-		codec := protocol.LengthFieldBasedFrameCodec{}
-		encodingMsg, err := proto.Marshal(msg)
-		logErr(err)
-		log.Printf("send data length(%d)\n", len(encodingMsg))
-		packet, err := codec.Encode(encodingMsg)
+		id, msg, err := spool.Next()
 		logErr(err)
-		_, err = c.Write(packet)
+		if !order.Acquire(id, msg) {
+			// sent once the entries of the object before it are done
+			continue
+		}
+		// what fits into a frame depends on the handshake
+		logErr(c.Connect())
+		if hello := negotiated.Load(); hello != nil && msg.GetType() == message.MessageType_S3_Object_Put_Begin && !protocol.Has(hello, protocol.CapChunking) {
+			// the server can't receive chunks, send the object inline
+			msg, err = minio.ReadObject(msg)
+			if minio.IsNotFound(err) {
+				log.Printf("read object bucket(%s) name(%s) failed, drop it: %v\n", msg.GetBucket(), msg.GetName(), err)
+				synced(id, msg)
+				continue
+			}
+			logErr(err)
+		}
+		if msg.GetType() == message.MessageType_S3_Object_Tagging && msg.GetMeta() == nil {
+			// the tags are read now, a failure is retried instead of losing the change
+			err := minio.ResolveTagging(msg)
+			if minio.IsNotFound(err) {
+				log.Printf("read tags of bucket(%s) name(%s) failed, drop it: %v\n", msg.GetBucket(), msg.GetName(), err)
+				synced(id, msg)
+				continue
+			}
+			if err != nil {
+				log.Printf("read tags of bucket(%s) name(%s) failed: %v\n", msg.GetBucket(), msg.GetName(), err)
+				order.Retry(id, msg)
+				continue
+			}
+		}
+		if msg.GetType() == message.MessageType_S3_Object_Put && bodySize(msg) > maxBodySize() {
+			// doesn't fit into a frame of this connection, stream it instead
+			msg = &message.MinioMessage{
+				Seq:     msg.GetSeq(),
+				Session: msg.GetSession(),
+				Type:    message.MessageType_S3_Object_Put_Begin,
+				Bucket:  msg.GetBucket(),
+				Name:    msg.GetName(),
+				Etag:    msg.GetEtag(),
+				Size:    int64(len(msg.GetContent())),
+			}
+		}
+		if msg.GetType() != message.MessageType_S3_Object_Put_Begin {
+			_, err := send(c, id, msg)
+			if errors.Is(err, protocol.ErrTooLargeBody) {
+				// only objects can be streamed, retrying would hold up the
+				// later entries of the key for good
+				log.Fatalf("message type(%s) bucket(%s) name(%s) exceeds the max frame size(%d), raise maxFrameSize of client and server\n", msg.GetType().String(), msg.GetBucket(), msg.GetName(), maxBodySize())
+			}
+			if err != nil {
+				// requeued by onConnState
+				log.Printf("send message type(%s) bucket(%s) name(%s) failed: %v\n", msg.GetType().String(), msg.GetBucket(), msg.GetName(), err)
+			}
+			continue
+		}
+		// large objects are streamed from minio, only the commit removes the entry
+		gen := disconnects.Load()
+		// leave room for the other fields of the chunk message
+		chunkSize := min(minio.ChunkSize, int64(maxBodySize()-proto.Size(msg)-chunkOverhead))
+		err = minio.StreamObject(msg, chunkSize, func(m *message.MinioMessage) (int64, error) {
+			acked, err := send(c, 0, m)
+			if err != nil {
+				return 0, errDisconnected
+			}
+			// wait for the server to tell how much of the object it already has
+			resp := <-acked
+			if resp == nil {
+				return 0, errDisconnected
+			}
+			if !resp.GetOk() {
+				return 0, fmt.Errorf("begin transfer bucket(%s) name(%s) failed on server", m.GetBucket(), m.GetName())
+			}
+			return resp.GetOffset(), nil
+		}, func(m *message.MinioMessage) error {
+			if disconnects.Load() != gen {
+				// chunks sent on the broken connection are lost
+				return errDisconnected
+			}
+			if m.GetType() == message.MessageType_S3_Object_Put_Commit {
+				// requeued by onConnState if it fails
+				_, err := send(c, id, m)
+				return err
+			}
+			if _, err := send(c, 0, m); err != nil {
+				return errDisconnected
+			}
+			return nil
+		})
+		if minio.IsNotFound(err) {
+			// the object is gone, a delete event follows it
+			log.Printf("read object bucket(%s) name(%s) failed, drop it: %v\n", msg.GetBucket(), msg.GetName(), err)
+			synced(id, msg)
+			continue
+		}
+		if err != nil {
+			log.Printf("stream object bucket(%s) name(%s) failed: %v\n", msg.GetBucket(), msg.GetName(), err)
+		}
+		if errors.Is(err, errDisconnected) {
+			// resume the transfer on the next connection
+			spool.Requeue(id)
+		} else if err != nil {
+			order.Retry(id, msg)
+		}
+	}
+}
+
+// send writes msg to the server. id is the journal entry removed once msg is
+// acked, 0 for messages without an entry of their own like object chunks.
+// The returned channel receives the ack of msg, or nil if the connection
+// broke before it arrived.
+func send(c *rconn.Conn, id uint64, msg *message.MinioMessage) (<-chan *message.RespMessage, error) {
+	log.Printf("send message seq(%d) type(%s) bucket(%s) name(%s)\n", msg.GetSeq(), msg.GetType().String(), msg.GetBucket(), msg.GetName())
+	// the codec depends on what the handshake negotiated
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	hello := negotiated.Load()
+	if protocol.Has(hello, protocol.CapCompression) {
+		compression.Compress(msg)
+	}
+	if msg.GetSession() != "" {
+		// lets the server forget the seqs that will never arrive
+		msg.Floor = spool.Floor()
+	}
+	codec := protocol.Codec(hello)
+	encodingMsg, err := proto.Marshal(msg)
+	logErr(err)
+	if protocol.Has(hello, protocol.CapEncryption) {
+		encodingMsg, err = keyring.Seal(encodingMsg)
 		logErr(err)
-		log.Printf("send message seq(%d) type(%s) bucket(%s) name(%s) done\n", msg.GetSeq(), msg.GetType().String(), msg.GetBucket(), msg.GetName())
+	}
+	log.Printf("send data length(%d)\n", len(encodingMsg))
+	packet, err := codec.Encode(encodingMsg)
+	if err != nil {
+		return nil, err
+	}
+	// blocks until the window has room
+	acked := outstanding.Add(id, msg, len(packet))
+	if _, err = c.Write(packet); err != nil {
+		return nil, err
+	}
+	log.Printf("send message seq(%d) type(%s) bucket(%s) name(%s) done\n", msg.GetSeq(), msg.GetType().String(), msg.GetBucket(), msg.GetName())
+	return acked, nil
+}
+
+// inventorySeqs numbers the inventory requests. They aren't journaled and
+// don't take seqs of the session, which the server would see as lost messages.
+var inventorySeqs atomic.Uint64
+
+// fetchInventory asks the server for a page of the objects the target has in
+// bucket after the key after, again on the next connection if the connection
+// breaks.
+func fetchInventory(c *rconn.Conn, bucket, after string) (*message.Inventory, error) {
+	for {
+		if err := c.Connect(); err != nil {
+			return nil, err
+		}
+		hello := negotiated.Load()
+		if !protocol.Has(hello, protocol.CapInventory) {
+			return nil, errors.New("server doesn't support inventories")
+		}
+		msg := &message.MinioMessage{
+			Seq:    inventorySeqs.Add(1),
+			Type:   message.MessageType_S3_Inventory,
+			Bucket: bucket,
+			Name:   after,
+		}
+		acked, err := send(c, 0, msg)
+		if err != nil {
+			continue
+		}
+		resp := <-acked
+		if resp == nil {
+			continue
+		}
+		if !resp.GetOk() {
+			return nil, fmt.Errorf("inventory of bucket(%s) failed on server", bucket)
+		}
+		data := resp.GetInventory()
+		if protocol.Has(hello, protocol.CapEncryption) {
+			if data, err = keyring.Open(data); err != nil {
+				return nil, err
+			}
+		}
+		inv := &message.Inventory{}
+		return inv, proto.Unmarshal(data, inv)
+	}
+}
+
+// synced removes the journal entry of msg once it needs no more sending and
+// records the object as synced for the full sync checkpoint.
+func synced(id uint64, msg *message.MinioMessage) {
+	logErr(spool.Remove(id))
+	order.Release(id, msg)
+	switch msg.GetType() {
+	case message.MessageType_S3_Object_Put, message.MessageType_S3_Object_Put_Begin, message.MessageType_S3_Object_Put_Commit:
+		logErr(fullSync.Acked(msg.GetBucket(), msg.GetName()))
+	}
+}
+
+// fullSync is the progress of the full sync.
+var fullSync *checkpoint.Checkpoint
+
+// recvAck reads the acks written by the server and marks the messages synced.
+func recvAck(c *rconn.Conn) {
+	for {
+		// buffered data of a broken connection must not leak into the next one
+		err := readAcks(bufio.NewReader(c))
+		log.Printf("receive ack failed: %v\n", err)
+		if errors.Is(err, protocol.ErrCheckFailPacket) || errors.Is(err, protocol.ErrInvalidMagicNumber) || errors.Is(err, protocol.ErrTooLargeBody) {
+			// the stream can't be trusted anymore, start over on a new connection
+			c.Break(err)
+		}
+		// the next read dials a new connection
+	}
+}
+
+func readAcks(r *bufio.Reader) error {
+	for {
+		// wait for data first, it may come from a new connection with a new codec
+		if _, err := r.Peek(1); err != nil {
+			return err
+		}
+		codec := protocol.Codec(negotiated.Load())
+		data, err := codec.DecodeReader(r)
+		if err != nil {
+			return err
+		}
+		resp := &message.RespMessage{}
+		logErr(proto.Unmarshal(data, resp))
+		p := outstanding.Remove(resp)
+		if p == nil {
+			log.Printf("receive ack of unknown seq(%d)\n", resp.GetSeq())
+			continue
+		}
+		p.acked <- resp
+		msg := p.msg
+		if !resp.GetOk() {
+			log.Printf("sync message seq(%d) type(%s) bucket(%s) name(%s) failed on server\n", msg.GetSeq(), msg.GetType().String(), msg.GetBucket(), msg.GetName())
+			if p.id != 0 {
+				order.Retry(p.id, msg)
+			}
+			continue
+		}
+		if p.id != 0 {
+			synced(p.id, msg)
+		}
+		log.Printf("sync message seq(%d) type(%s) bucket(%s) name(%s) acked, outstanding(%d)\n", msg.GetSeq(), msg.GetType().String(), msg.GetBucket(), msg.GetName(), outstanding.Len())
 	}
 }
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/message"
+	rconn "github.com/yimiaoxiehou/minio-sync/internal/reconnectconn"
+)
+
+// keyOrder keeps the journal entries of an object in order on the client.
+//
+// An entry is only sent once every earlier entry of the same object was
+// acked or dropped. A failed entry is sent again after a backoff while the
+// entries following it wait, so e.g. a delete never overtakes the put it
+// follows, and a put retried later can't bring back a deleted object.
+type keyOrder struct {
+	lock    sync.Mutex
+	backoff rconn.Backoff
+	owner   map[string]uint64   // 每个对象正在发送或等待重试的 entry
+	parked  map[string][]uint64 // 排在它后面的 entry, 按 id 排序
+	tries   map[uint64]int      // entry 失败的次数
+}
+
+func newKeyOrder(backoff rconn.Backoff) *keyOrder {
+	return &keyOrder{
+		backoff: backoff,
+		owner:   make(map[string]uint64),
+		parked:  make(map[string][]uint64),
+		tries:   make(map[uint64]int),
+	}
+}
+
+func entryKey(msg *message.MinioMessage) string {
+	return msg.GetBucket() + "/" + msg.GetName()
+}
+
+// Acquire reports whether entry id of msg can be sent now. If not it is
+// parked and requeued once the entries of the object before it are done.
+func (o *keyOrder) Acquire(id uint64, msg *message.MinioMessage) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	k := entryKey(msg)
+	owner, ok := o.owner[k]
+	if !ok {
+		o.owner[k] = id
+		return true
+	}
+	if owner == id {
+		return true
+	}
+	o.parked[k] = insertId(o.parked[k], id)
+	return false
+}
+
+// Release records that entry id of msg is done, the next entry of the object
+// is requeued.
+func (o *keyOrder) Release(id uint64, msg *message.MinioMessage) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	delete(o.tries, id)
+	k := entryKey(msg)
+	if o.owner[k] != id {
+		return
+	}
+	next := o.parked[k]
+	if len(next) == 0 {
+		delete(o.owner, k)
+		return
+	}
+	o.owner[k] = next[0]
+	if len(next) == 1 {
+		delete(o.parked, k)
+	} else {
+		o.parked[k] = next[1:]
+	}
+	spool.Requeue(next[0])
+}
+
+// Retry requeues entry id of msg after a backoff, the object's later entries
+// keep waiting for it.
+func (o *keyOrder) Retry(id uint64, msg *message.MinioMessage) {
+	o.lock.Lock()
+	o.tries[id]++
+	d := o.backoff.Delay(o.tries[id])
+	o.lock.Unlock()
+	log.Printf("retry message seq(%d) type(%s) bucket(%s) name(%s) in %s\n", msg.GetSeq(), msg.GetType().String(), msg.GetBucket(), msg.GetName(), d)
+	time.AfterFunc(d, func() { spool.Requeue(id) })
+}
+
+func insertId(ids []uint64, id uint64) []uint64 {
+	i := len(ids)
+	for i > 0 && ids[i-1] > id {
+		i--
+	}
+	if i > 0 && ids[i-1] == id {
+		// requeued twice, e.g. by a broken connection
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+// order serializes the entries of each object.
+var order *keyOrder
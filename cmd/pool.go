@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/panjf2000/gnet/v2"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/message"
+)
+
+// queueSize is how many frames a worker queues before the connections feeding
+// it are paused.
+const queueSize = 64
+
+// workerPool applies the received frames on a fixed number of goroutines.
+//
+// Frames are sharded by bucket/name, so the messages of an object are applied
+// in the order they arrived while other objects proceed concurrently. IAM and
+// bucket metadata affect every object, they are barriers applied once all
+// frames queued before them are applied and before any queued after them.
+//
+// A frame whose worker's queue is full is kept by its connection, which
+// stops decoding the following frames and is woken up once the worker catches
+// up. The event loop never blocks on a worker.
+type workerPool struct {
+	workers []*worker
+	apply   func(f *frame)
+}
+
+type worker struct {
+	frames  chan *frame
+	lock    sync.Mutex
+	waiting map[gnet.Conn]struct{} // 等待队列空出位置的连接
+}
+
+// barrier is queued on every worker in place of a frame that must be applied
+// alone.
+type barrier struct {
+	arrived sync.WaitGroup // 所有 worker 都处理完 barrier 之前的 frame
+	done    chan struct{}
+}
+
+func newWorkerPool(n int, apply func(f *frame)) *workerPool {
+	p := &workerPool{apply: apply}
+	for i := 0; i < n; i++ {
+		w := &worker{frames: make(chan *frame, queueSize), waiting: make(map[gnet.Conn]struct{})}
+		p.workers = append(p.workers, w)
+	}
+	for i, w := range p.workers {
+		go w.run(p, i == 0)
+	}
+	return p
+}
+
+// isBarrier reports whether msg has to be applied with no other message in
+// progress.
+func isBarrier(msg *message.MinioMessage) bool {
+	switch msg.GetType() {
+	case message.MessageType_Minio_IAM_Export, message.MessageType_Minio_BUCKETS_Export:
+		return true
+	}
+	return false
+}
+
+// targets returns the workers f is queued on.
+func (p *workerPool) targets(f *frame) []*worker {
+	if isBarrier(f.msg) {
+		return p.workers
+	}
+	h := fnv.New32a()
+	h.Write([]byte(f.msg.GetBucket()))
+	h.Write([]byte{'/'})
+	h.Write([]byte(f.msg.GetName()))
+	return []*worker{p.workers[h.Sum32()%uint32(len(p.workers))]}
+}
+
+// Submit queues f unless the queue of one of its workers is full. Then false
+// is returned and the connection of f is woken up once there is room. It must
+// only be called by the event loop.
+func (p *workerPool) Submit(f *frame) bool {
+	ws := p.targets(f)
+	for _, w := range ws {
+		if !w.ready(f.conn) {
+			return false
+		}
+	}
+	if len(ws) > 1 {
+		f.barrier = &barrier{done: make(chan struct{})}
+		f.barrier.arrived.Add(len(ws))
+	}
+	for _, w := range ws {
+		w.frames <- f
+	}
+	return true
+}
+
+// ready reports whether the worker can take another frame. If not, c is
+// woken up once it can.
+func (w *worker) ready(c gnet.Conn) bool {
+	if len(w.frames) < cap(w.frames) {
+		return true
+	}
+	w.lock.Lock()
+	w.waiting[c] = struct{}{}
+	w.lock.Unlock()
+	// the worker may have emptied the queue before c was registered
+	return len(w.frames) < cap(w.frames)
+}
+
+// run applies the frames of the worker. The first worker applies barriers,
+// the others wait for it.
+func (w *worker) run(p *workerPool, first bool) {
+	for f := range w.frames {
+		w.wake()
+		b := f.barrier
+		if b == nil {
+			p.apply(f)
+			continue
+		}
+		b.arrived.Done()
+		if first {
+			b.arrived.Wait()
+			p.apply(f)
+			close(b.done)
+		} else {
+			<-b.done
+		}
+	}
+}
+
+// wake resumes the connections paused because the queue was full.
+func (w *worker) wake() {
+	w.lock.Lock()
+	if len(w.waiting) == 0 {
+		w.lock.Unlock()
+		return
+	}
+	// ready adds to the map, only the swapped out one is read unlocked
+	waiting := w.waiting
+	w.waiting = make(map[gnet.Conn]struct{})
+	w.lock.Unlock()
+	for c := range waiting {
+		// stale wakes of closed connections are ignored by gnet
+		c.Wake(nil)
+	}
+}
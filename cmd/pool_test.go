@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/panjf2000/gnet/v2"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/message"
+)
+
+// fakeConn is the connection of the frames, the pool only wakes it.
+type fakeConn struct {
+	gnet.Conn
+	woken chan struct{}
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{woken: make(chan struct{}, 1)}
+}
+
+func (c *fakeConn) Wake(gnet.AsyncCallback) error {
+	select {
+	case c.woken <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// submitAll submits the frames in order like the event loop does, waiting
+// for a wake whenever a queue is full.
+func submitAll(p *workerPool, c *fakeConn, frames []*frame) {
+	for _, f := range frames {
+		for !p.Submit(f) {
+			<-c.woken
+		}
+	}
+}
+
+func newFrame(c *fakeConn, seq uint64, typ message.MessageType, bucket, name string) *frame {
+	return &frame{conn: c, msg: &message.MinioMessage{Seq: seq, Type: typ, Bucket: bucket, Name: name}}
+}
+
+func TestWorkerPoolKeepsOrderPerObject(t *testing.T) {
+	const keys, perKey = 50, 40
+	c := newFakeConn()
+	var frames []*frame
+	seq := uint64(0)
+	// the messages of every object interleave with the ones of all others
+	for i := 0; i < perKey; i++ {
+		for k := 0; k < keys; k++ {
+			seq++
+			typ := message.MessageType_S3_Object_Put
+			if i%2 == 1 {
+				typ = message.MessageType_S3_Obejct_Delete
+			}
+			frames = append(frames, newFrame(c, seq, typ, fmt.Sprintf("bucket%d", k%3), fmt.Sprintf("key%d", k)))
+		}
+	}
+
+	var lock sync.Mutex
+	got := make(map[string][]*message.MinioMessage)
+	var wg sync.WaitGroup
+	wg.Add(len(frames))
+	p := newWorkerPool(4, func(f *frame) {
+		defer wg.Done()
+		if f.msg.GetSeq()%5 == 0 {
+			runtime.Gosched()
+		}
+		lock.Lock()
+		k := entryKey(f.msg)
+		got[k] = append(got[k], f.msg)
+		lock.Unlock()
+	})
+	submitAll(p, c, frames)
+	wg.Wait()
+
+	if len(got) != keys {
+		t.Fatalf("applied %d objects, want %d", len(got), keys)
+	}
+	for k, msgs := range got {
+		if len(msgs) != perKey {
+			t.Fatalf("%s: applied %d messages, want %d", k, len(msgs), perKey)
+		}
+		for i, msg := range msgs {
+			if i > 0 && msg.GetSeq() <= msgs[i-1].GetSeq() {
+				t.Fatalf("%s: seq(%d) applied after seq(%d)", k, msg.GetSeq(), msgs[i-1].GetSeq())
+			}
+			// put and delete alternate, a delete never overtakes its put
+			if want := i%2 == 1; (msg.GetType() == message.MessageType_S3_Obejct_Delete) != want {
+				t.Fatalf("%s: message %d is a %s", k, i, msg.GetType())
+			}
+		}
+	}
+}
+
+func TestWorkerPoolBarriers(t *testing.T) {
+	c := newFakeConn()
+	var frames []*frame
+	for seq := uint64(1); seq <= 1000; seq++ {
+		switch {
+		case seq%200 == 0:
+			frames = append(frames, newFrame(c, seq, message.MessageType_Minio_IAM_Export, "", ""))
+		case seq%100 == 0:
+			frames = append(frames, newFrame(c, seq, message.MessageType_Minio_BUCKETS_Export, "", ""))
+		default:
+			frames = append(frames, newFrame(c, seq, message.MessageType_S3_Object_Put, "bucket", fmt.Sprintf("key%d", seq%37)))
+		}
+	}
+
+	var lock sync.Mutex
+	active := 0
+	var applied []uint64 // seqs in the order they finished
+	var wg sync.WaitGroup
+	wg.Add(len(frames))
+	p := newWorkerPool(4, func(f *frame) {
+		defer wg.Done()
+		if isBarrier(f.msg) {
+			lock.Lock()
+			n := active
+			lock.Unlock()
+			if n != 0 {
+				t.Errorf("barrier seq(%d) started with %d frames in progress", f.msg.GetSeq(), n)
+			}
+			runtime.Gosched()
+			lock.Lock()
+			if active != 0 {
+				t.Errorf("barrier seq(%d) overlapped %d frames", f.msg.GetSeq(), active)
+			}
+			applied = append(applied, f.msg.GetSeq())
+			lock.Unlock()
+			return
+		}
+		lock.Lock()
+		active++
+		lock.Unlock()
+		runtime.Gosched()
+		lock.Lock()
+		active--
+		applied = append(applied, f.msg.GetSeq())
+		lock.Unlock()
+	})
+	submitAll(p, c, frames)
+	wg.Wait()
+
+	if len(applied) != len(frames) {
+		t.Fatalf("applied %d frames, want %d", len(applied), len(frames))
+	}
+	pos := make(map[uint64]int, len(applied))
+	for i, seq := range applied {
+		pos[seq] = i
+	}
+	for _, b := range frames {
+		if !isBarrier(b.msg) {
+			continue
+		}
+		bseq := b.msg.GetSeq()
+		for _, f := range frames {
+			seq := f.msg.GetSeq()
+			if seq < bseq && pos[seq] > pos[bseq] {
+				t.Fatalf("seq(%d) queued before barrier seq(%d) was applied after it", seq, bseq)
+			}
+			if seq > bseq && pos[seq] < pos[bseq] {
+				t.Fatalf("seq(%d) queued after barrier seq(%d) was applied before it", seq, bseq)
+			}
+		}
+	}
+}
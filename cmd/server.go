@@ -1,14 +1,25 @@
 package cmd
 
 import (
+	"bytes"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/panjf2000/gnet/v2"
 	"google.golang.org/protobuf/proto"
 
 	"log"
 
+	"github.com/yimiaoxiehou/minio-sync/internal/compression"
+	"github.com/yimiaoxiehou/minio-sync/internal/dedup"
+	"github.com/yimiaoxiehou/minio-sync/internal/envelope"
 	"github.com/yimiaoxiehou/minio-sync/internal/message"
 	"github.com/yimiaoxiehou/minio-sync/internal/minio"
 	"github.com/yimiaoxiehou/minio-sync/internal/protocol"
@@ -21,12 +32,49 @@ type server struct {
 	network   string
 	addr      string
 	multicore bool
+	proxied   bool // 连接来自 tls listener, 先发过来客户端的地址
 	connected int32
+	key       []byte // 预共享密钥, 客户端握手时要证明知道它
+
+	lock     sync.Mutex
+	unauthed map[gnet.Conn]time.Time // 还没通过握手的连接和它们打开的时间
+}
+
+// connContext is the state of a client connection.
+type connContext struct {
+	codec     protocol.LengthFieldBasedFrameCodec
+	challenge *message.Challenge
+	authed    bool           // 握手成功之前不接受任何消息
+	hello     *message.Hello // 协商好的协议版本和能力
+	inbound   bytes.Buffer   // 收到还没解码的数据
+	pending   *frame         // worker 忙, 还没交给它的 frame
+	remote    string         // 客户端地址
+}
+
+// maxAddrLine bounds the address line the tls listener sends first.
+const maxAddrLine = 256
+
+// authTimeout is how long a connection may take to answer the challenge,
+// at most maxUnauthed connections wait for it at the same time.
+const (
+	authTimeout = 10 * time.Second
+	maxUnauthed = 256
+)
+
+// frame is a decoded message together with the connection it arrived on,
+// so the ack can be written back to the right client.
+type frame struct {
+	conn    gnet.Conn
+	msg     *message.MinioMessage
+	barrier *barrier // 不为 nil 时要等其他 worker 都停下才能处理
 }
 
-var dataBuffer chan ([]byte) = make(chan ([]byte))
+// pool applies the frames of all connections.
+var pool *workerPool
 
-// var respBuffer chan (*message.RespMessage) = make(chan (*message.RespMessage))
+// serverKeyring decrypts the messages of connections that negotiated
+// CapEncryption.
+var serverKeyring *envelope.Keyring
 
 // OnBoot description of the Go function.
 //
@@ -49,62 +97,382 @@ func (s *server) OnBoot(eng gnet.Engine) (action gnet.Action) {
 //
 //	out []byte, action gnet.Action
 func (s *server) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
-	c.SetContext(new(protocol.LengthFieldBasedFrameCodec))
+	s.lock.Lock()
+	full := len(s.unauthed) >= maxUnauthed
+	if !full {
+		s.unauthed[c] = time.Now()
+	}
+	s.lock.Unlock()
+	if full {
+		log.Printf("%d connections are waiting for the handshake, refuse %s\n", maxUnauthed, remoteAddr(c))
+		return nil, gnet.Close
+	}
+	ctx := &connContext{}
+	if !s.proxied {
+		ctx.remote = c.RemoteAddr().String()
+	}
+	var err error
+	ctx.challenge, err = protocol.NewChallenge()
+	if err == nil {
+		out, err = ctx.codec.EncodeMessage(ctx.challenge)
+	}
+	if err != nil {
+		log.Printf("challenge %s failed: %v\n", remoteAddr(c), err)
+		return nil, gnet.Close
+	}
+	c.SetContext(ctx)
 	atomic.AddInt32(&s.connected, 1)
-	out = []byte(protocol.ConnectedAck)
 	return
 }
 
+// OnClose forgets c if it closed before the handshake.
+func (s *server) OnClose(c gnet.Conn, err error) (action gnet.Action) {
+	s.lock.Lock()
+	delete(s.unauthed, c)
+	s.lock.Unlock()
+	return
+}
+
+// OnTick closes the connections that didn't answer the challenge within
+// authTimeout.
+func (s *server) OnTick() (delay time.Duration, action gnet.Action) {
+	now := time.Now()
+	s.lock.Lock()
+	for c, opened := range s.unauthed {
+		if now.Sub(opened) > authTimeout {
+			// 不读 c.Context(), 它属于 event loop
+			log.Printf("%s didn't authenticate within %s, close it\n", c.RemoteAddr(), authTimeout)
+			delete(s.unauthed, c)
+			c.Close(nil) // 异步关闭, 可以在 ticker goroutine 里调用
+		}
+	}
+	s.lock.Unlock()
+	return time.Second, gnet.None
+}
+
 // OnTraffic handles the traffic for the server.
 //
-// It takes a gnet.Conn as a parameter and returns a gnet.Action.
+// It decodes every complete frame buffered for c and hands them to the worker
+// pool. If the pool is busy the frame is kept and the remaining ones stay
+// buffered in the connection's context, c is woken up again once a worker has
+// room.
 func (s *server) OnTraffic(c gnet.Conn) (action gnet.Action) {
-	codec := c.Context().(*protocol.LengthFieldBasedFrameCodec)
-	data, err := codec.Decode(c)
-	if err == protocol.ErrIncompletePacket {
-		return
+	ctx := c.Context().(*connContext)
+	// take everything gnet buffered, OnTraffic called by Wake would see the
+	// data left in gnet's read buffer a second time
+	buf, _ := c.Next(-1)
+	ctx.inbound.Write(buf)
+	if ctx.pending != nil && ctx.authed && ctx.hello.GetWindowBytes() != 0 &&
+		ctx.inbound.Len() > int(ctx.hello.GetWindowBytes())+int(ctx.hello.GetMaxBodySize()) {
+		// gnet keeps reading while the workers are busy, only a client
+		// ignoring the negotiated window sends that much ahead of the acks
+		log.Printf("%s sent more than the window(%d) ahead of the acks, close it\n", remoteAddr(c), ctx.hello.GetWindowBytes())
+		return gnet.Close
+	}
+	if ctx.remote == "" {
+		// a connection forwarded by the tls listener starts with the client's address
+		i := bytes.IndexByte(ctx.inbound.Bytes(), '\n')
+		if i < 0 {
+			if ctx.inbound.Len() > maxAddrLine {
+				log.Printf("no client address from tls listener, close it\n")
+				return gnet.Close
+			}
+			return
+		}
+		ctx.remote = string(ctx.inbound.Next(i + 1)[:i])
+	}
+	if ctx.pending != nil {
+		if !pool.Submit(ctx.pending) {
+			return
+		}
+		ctx.pending = nil
+	}
+	for {
+		data, err := ctx.codec.DecodeBuffer(&ctx.inbound)
+		if err == protocol.ErrIncompletePacket {
+			return
+		}
+		if err != nil {
+			// the client sends everything not acked again on a new connection
+			log.Printf("invalid packet from %s: %v, close it\n", remoteAddr(c), err)
+			return gnet.Close
+		}
+		if !ctx.authed {
+			if action = s.authenticate(c, ctx, data); action != gnet.None {
+				return
+			}
+			continue
+		}
+		log.Printf("receive data length(%d)\n", len(data))
+		f, err := decodeFrame(c, ctx, data)
+		if err != nil {
+			// can't even tell the seq, drop the connection and let the client resend
+			log.Printf("decode data from %s failed: %v, close it\n", remoteAddr(c), err)
+			return gnet.Close
+		}
+		if !pool.Submit(f) {
+			ctx.pending = f
+			return
+		}
+	}
+}
+
+// decodeFrame decrypts and unmarshals the message in data, the pool needs it
+// to tell which object the frame is about.
+func decodeFrame(c gnet.Conn, ctx *connContext, data []byte) (*frame, error) {
+	if protocol.Has(ctx.hello, protocol.CapEncryption) {
+		var err error
+		if data, err = serverKeyring.Open(data); err != nil {
+			return nil, err
+		}
+	}
+	// Unmarshal copies data, which points into ctx.inbound
+	msg := &message.MinioMessage{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return &frame{conn: c, msg: msg}, nil
+}
+
+// authenticate checks the client's answer to the challenge sent by OnOpen,
+// negotiates the protocol version and closes the connection if either fails.
+func (s *server) authenticate(c gnet.Conn, ctx *connContext, data []byte) gnet.Action {
+	auth := &message.Auth{}
+	if err := proto.Unmarshal(data, auth); err != nil {
+		log.Printf("authenticate %s failed: %v\n", remoteAddr(c), err)
+		return gnet.Close
+	}
+	result, err := protocol.ServerHandshake(s.key, ctx.challenge, auth)
+	if result != nil {
+		out, werr := ctx.codec.EncodeMessage(result)
+		if werr == nil {
+			_, werr = c.Write(out)
+		}
+		if err == nil {
+			err = werr
+		}
 	}
 	if err != nil {
-		log.Fatalf("invalid packet: %v", err)
-	}
-	log.Printf("receive data length(%d)\n", len(data))
-	dataBuffer <- data
-	// resp := <-respBuffer
-	// log.Println(resp)
-	// res := resp.EncodeToByte()
-	// _, _ = c.Write([]byte{res})
-	return
+		log.Printf("authenticate %s failed: %v\n", remoteAddr(c), err)
+		return gnet.Close
+	}
+	ctx.authed = true
+	s.lock.Lock()
+	delete(s.unauthed, c)
+	s.lock.Unlock()
+	ctx.hello = result.GetHello()
+	ctx.codec = protocol.Codec(ctx.hello)
+	log.Printf("authenticated %s, protocol version(%d) capabilities(%b)\n", remoteAddr(c), ctx.hello.GetVersion(), ctx.hello.GetCapabilities())
+	return gnet.None
 }
 
-func RunServer(addr string) {
-	go received()
+// ServerOptions configures RunServer.
+type ServerOptions struct {
+	Addr    string
+	TLS     *tls.Config       // nil 时不加密
+	Key     []byte            // 预共享密钥
+	Keyring *envelope.Keyring // 解密消息的密钥, 不为 nil 时要求客户端加密
+	DataDir string            // 记录每个客户端 session 已经处理过的消息
+	Workers int               // 并发处理消息的 goroutine 数
+}
+
+func RunServer(opts ServerOptions) {
+	serverKeyring = opts.Keyring
+	var err error
+	if applied, err = dedup.Open(opts.DataDir); err != nil {
+		log.Fatalln(err)
+	}
+	if err = minio.InitTransfers(filepath.Join(opts.DataDir, "transfers")); err != nil {
+		log.Fatalln(err)
+	}
+	pool = newWorkerPool(opts.Workers, apply)
 
 	ss := &server{
 		network:   "tcp",
-		addr:      addr,
+		addr:      opts.Addr,
 		multicore: false,
+		key:       opts.Key,
+		unauthed:  make(map[gnet.Conn]time.Time),
+	}
+	if opts.TLS != nil {
+		// gnet can't speak tls, it serves a private unix socket behind a tls listener
+		dir, err := os.MkdirTemp("", "minio-sync-")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer os.RemoveAll(dir)
+		// only this user may connect, the socket skips the tls client authentication
+		if err := os.Chmod(dir, 0o700); err != nil {
+			log.Fatalln(err)
+		}
+		ss.network = "unix"
+		ss.proxied = true
+		ss.addr = filepath.Join(dir, "server.sock")
+		go serveTLS(opts.Addr, ss.addr, opts.TLS)
 	}
-	err := gnet.Run(ss, ss.network+"://"+ss.addr, gnet.WithMulticore(false))
+	err = gnet.Run(ss, ss.network+"://"+ss.addr, gnet.WithMulticore(false), gnet.WithTicker(true))
 	log.Printf("server exits with error: %v\n", err)
 }
 
-func received() {
-	for {
-		data := <-dataBuffer
-		msgRec := message.MinioMessage{}
-		err := proto.Unmarshal(data, &msgRec)
-		if err != nil {
-			fmt.Println(data)
-			log.Panicln(err)
+// apply applies the message of a frame and acks it.
+func apply(f *frame) {
+	msgRec := f.msg
+	resp := &message.RespMessage{
+		Seq:  msgRec.GetSeq(),
+		Type: msgRec.GetType(),
+		Ok:   true,
+	}
+	if msgRec.GetType() == message.MessageType_S3_Inventory {
+		// only reads, nothing to deduplicate
+		if err := inventory(f.conn, msgRec, resp); err != nil {
+			log.Printf("inventory of bucket(%s) failed: %v\n", msgRec.GetBucket(), err)
+			resp.Ok = false
+		}
+		reply(f.conn, resp)
+		return
+	}
+	if isApplied(msgRec) {
+		log.Printf("skip msg session(%s) seq(%d) type(%s), applied already\n", msgRec.GetSession(), msgRec.GetSeq(), msgRec.GetType().String())
+		if msgRec.GetType() == message.MessageType_S3_Object_Put_Begin {
+			// nothing left to send, go straight to the commit
+			resp.Offset = msgRec.GetSize()
 		}
-		err = minio.ProcessMinioEvent(&msgRec)
+		reply(f.conn, resp)
+		return
+	}
+	if err := compression.Decompress(msgRec); err != nil {
+		log.Printf("decompress msg seq(%d) failed: %v\n", msgRec.GetSeq(), err)
+		resp.Ok = false
+		reply(f.conn, resp)
+		return
+	}
+	var err error
+	if msgRec.GetType() == message.MessageType_S3_Object_Put_Begin {
+		// tell the client where to resume an interrupted transfer
+		resp.Offset, err = minio.BeginTransfer(msgRec)
+	} else {
+		err = minio.ProcessMinioEvent(msgRec)
+	}
+	if err == nil && completes(msgRec) {
+		err = applied.Mark(msgRec.GetSession(), msgRec.GetSeq(), msgRec.GetFloor())
+	}
+	if err != nil {
+		log.Printf("process msg seq(%d) failed: %v\n", msgRec.GetSeq(), err)
+		resp.Ok = false
+	}
+	if msgRec.GetType() == message.MessageType_S3_Object_Put_Chunk {
+		// the client tells the acks of a stream's chunks apart by offset
+		resp.Offset = msgRec.GetOffset()
+	}
+	reply(f.conn, resp)
+}
+
+// inventory puts a page of the objects the client asked for with a
+// S3_Inventory message into resp, encrypted if the connection negotiated
+// CapEncryption.
+func inventory(c gnet.Conn, msg *message.MinioMessage, resp *message.RespMessage) error {
+	ctx := c.Context().(*connContext)
+	// leave room for the rest of the reply and the encryption envelope
+	inv, err := minio.Inventory(msg.GetBucket(), msg.GetName(), int(ctx.hello.GetMaxBodySize())/2)
+	if err != nil {
+		return err
+	}
+	data, err := proto.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	if protocol.Has(ctx.hello, protocol.CapEncryption) {
+		if data, err = serverKeyring.Seal(data); err != nil {
+			return err
+		}
+	}
+	resp.Inventory = data
+	return nil
+}
+
+// applied records the messages applied per client session, resent ones are
+// acked without applying them again.
+var applied *dedup.Store
+
+// completes reports whether msg is the last message of what its seq stands
+// for, i.e. not the begin or a chunk of an object stream.
+func completes(msg *message.MinioMessage) bool {
+	switch msg.GetType() {
+	case message.MessageType_S3_Object_Put_Begin, message.MessageType_S3_Object_Put_Chunk:
+		return false
+	}
+	return true
+}
+
+// isApplied reports whether msg was applied already. For the begin of an
+// object stream that is whether the stream was committed.
+func isApplied(msg *message.MinioMessage) bool {
+	if msg.GetType() == message.MessageType_S3_Object_Put_Chunk {
+		return false
+	}
+	return applied.Applied(msg.GetSession(), msg.GetSeq())
+}
+
+// remoteAddr returns the address of the client of c.
+func remoteAddr(c gnet.Conn) string {
+	if ctx, ok := c.Context().(*connContext); ok && ctx.remote != "" {
+		return ctx.remote
+	}
+	return c.RemoteAddr().String()
+}
+
+// reply writes the ack of a processed message back to the client.
+func reply(c gnet.Conn, resp *message.RespMessage) {
+	codec := c.Context().(*connContext).codec
+	data, err := codec.EncodeMessage(resp)
+	if err == nil {
+		err = c.AsyncWrite(data, nil)
+	}
+	if err != nil {
+		log.Printf("reply seq(%d) to %s failed: %v\n", resp.GetSeq(), remoteAddr(c), err)
+	}
+}
+
+// serveTLS accepts tls connections on addr and forwards the decrypted stream
+// of each of them to the unix socket backend.
+func serveTLS(addr, backend string, cfg *tls.Config) {
+	ln, err := tls.Listen("tcp", addr, cfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("running tls listener on %s\n", addr)
+	for {
+		conn, err := ln.Accept()
 		if err != nil {
-			log.Panicln(err)
+			log.Printf("accept tls connection failed: %v\n", err)
+			continue
 		}
-		// resp := &message.RespMessage{
-		// 	Seq: msgRec.Seq,
-		// 	Ok:  true,
-		// }
-		// respBuffer <- resp
+		go proxy(conn.(*tls.Conn), backend)
+	}
+}
+
+func proxy(conn *tls.Conn, backend string) {
+	defer conn.Close()
+	// handshake first, so clients without a valid certificate never reach gnet
+	if err := conn.Handshake(); err != nil {
+		log.Printf("tls handshake with %s failed: %v\n", conn.RemoteAddr(), err)
+		return
+	}
+	b, err := net.Dial("unix", backend)
+	if err != nil {
+		log.Printf("connect %s failed: %v\n", backend, err)
+		return
+	}
+	defer b.Close()
+	log.Printf("tls connection from %s\n", conn.RemoteAddr())
+	// gnet only sees the unix socket, tell it who the client is
+	if _, err := fmt.Fprintf(b, "%s\n", conn.RemoteAddr()); err != nil {
+		log.Printf("forward tls connection from %s failed: %v\n", conn.RemoteAddr(), err)
+		return
 	}
+	go func() {
+		io.Copy(b, conn)
+		b.(*net.UnixConn).CloseWrite()
+	}()
+	io.Copy(conn, b)
 }
@@ -0,0 +1,166 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/atomicfile"
+)
+
+// saveInterval is how often progress is written to disk at most. Progress
+// lost in a crash only means listing a few objects again.
+const saveInterval = time.Second
+
+// Bucket is the persisted progress of a bucket.
+type Bucket struct {
+	After string `json:"after,omitempty"` // 这个 key 及之前的对象服务端都已经 ack
+	Done  bool   `json:"done,omitempty"`
+}
+
+// progress is the in-memory state of a bucket being listed.
+type progress struct {
+	keys   []string // 已列出还没 ack 的 key, 按列出的顺序
+	acked  map[string]bool
+	listed bool // 已经列完
+}
+
+// Checkpoint records how far the full sync got, so a restarted client
+// continues after the last object of each bucket the server acknowledged
+// instead of starting over.
+//
+// Objects are acked out of order, a bucket only advances to a key once every
+// key listed before it is acked too. The checkpoint is removed once every
+// bucket is done, the next start syncs everything again.
+type Checkpoint struct {
+	path     string
+	lock     sync.Mutex
+	buckets  map[string]*Bucket
+	progress map[string]*progress
+	saved    time.Time
+}
+
+// Open loads the checkpoint at path, an empty one if there is none.
+func Open(path string) (*Checkpoint, error) {
+	cp := &Checkpoint{path: path, buckets: make(map[string]*Bucket), progress: make(map[string]*progress)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cp.buckets); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Reset forgets the progress, the full sync starts over.
+func (cp *Checkpoint) Reset() error {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	cp.buckets = make(map[string]*Bucket)
+	cp.progress = make(map[string]*progress)
+	err := os.Remove(cp.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Start returns where to continue listing bucket, done if it is synced
+// completely. It must be called for all buckets before any is listed.
+func (cp *Checkpoint) Start(bucket string) (after string, done bool) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	b := cp.bucket(bucket)
+	if !b.Done {
+		cp.progress[bucket] = &progress{acked: make(map[string]bool)}
+	}
+	return b.After, b.Done
+}
+
+// Listed records that key was listed and is being sent. Keys must be listed
+// in order.
+func (cp *Checkpoint) Listed(bucket, key string) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	if p := cp.progress[bucket]; p != nil {
+		p.keys = append(p.keys, key)
+	}
+}
+
+// Finish records that every key of bucket was listed.
+func (cp *Checkpoint) Finish(bucket string) error {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	if p := cp.progress[bucket]; p != nil {
+		p.listed = true
+		return cp.advance(bucket, p)
+	}
+	return nil
+}
+
+// Acked records that the server acknowledged key, or that it doesn't have to
+// be sent anymore.
+func (cp *Checkpoint) Acked(bucket, key string) error {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	p := cp.progress[bucket]
+	if p == nil {
+		return nil
+	}
+	p.acked[key] = true
+	return cp.advance(bucket, p)
+}
+
+func (cp *Checkpoint) advance(bucket string, p *progress) error {
+	b := cp.bucket(bucket)
+	for len(p.keys) > 0 && p.acked[p.keys[0]] {
+		b.After = p.keys[0]
+		delete(p.acked, p.keys[0])
+		p.keys = p.keys[1:]
+	}
+	if !p.listed || len(p.keys) > 0 {
+		if time.Since(cp.saved) < saveInterval {
+			return nil
+		}
+		return cp.save()
+	}
+	b.Done = true
+	delete(cp.progress, bucket)
+	if len(cp.progress) == 0 {
+		// full sync complete
+		cp.buckets = make(map[string]*Bucket)
+		err := os.Remove(cp.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return cp.save()
+}
+
+func (cp *Checkpoint) bucket(name string) *Bucket {
+	b := cp.buckets[name]
+	if b == nil {
+		b = &Bucket{}
+		cp.buckets[name] = b
+	}
+	return b
+}
+
+// save writes the checkpoint to disk.
+func (cp *Checkpoint) save() error {
+	data, err := json.Marshal(cp.buckets)
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.Write(cp.path, data); err != nil {
+		return err
+	}
+	cp.saved = time.Now()
+	return nil
+}
@@ -0,0 +1,126 @@
+package compression
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/message"
+	"github.com/yimiaoxiehou/minio-sync/internal/protocol"
+)
+
+// DefaultLevel is the default zstd level of the client.
+const DefaultLevel = 3
+
+// minSize is the smallest content worth compressing.
+const minSize = 512
+
+var (
+	level   = DefaultLevel
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	once    sync.Once
+)
+
+// SetLevel sets the zstd level (1 to 22) used by Compress, 0 turns
+// compression off. It must be called before the first Compress.
+func SetLevel(l int) {
+	level = l
+}
+
+func initCodec() {
+	var err error
+	encoder, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(max(level, 1))))
+	if err != nil {
+		panic(err)
+	}
+	// the content had to fit into a frame before it was compressed
+	decoder, err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(protocol.MaxBodySize)))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// compressedExts are file types whose content doesn't shrink anymore.
+var compressedExts = map[string]bool{
+	".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".lz4": true,
+	".zip": true, ".7z": true, ".rar": true, ".jar": true, ".apk": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp3": true, ".aac": true, ".ogg": true, ".flac": true,
+	".mp4": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true,
+	".pdf": true, ".docx": true, ".xlsx": true, ".pptx": true,
+}
+
+// compressedTypes are content types whose content doesn't shrink anymore,
+// besides images, audio and video.
+var compressedTypes = map[string]bool{
+	"application/zip": true, "application/gzip": true, "application/x-gzip": true,
+	"application/x-bzip2": true, "application/x-xz": true, "application/zstd": true,
+	"application/x-7z-compressed": true, "application/x-rar-compressed": true, "application/vnd.rar": true,
+	"application/java-archive": true, "application/vnd.android.package-archive": true, "application/pdf": true,
+}
+
+func compressedType(ct string) bool {
+	ct, _, _ = strings.Cut(strings.ToLower(ct), ";")
+	ct = strings.TrimSpace(ct)
+	switch {
+	case strings.HasPrefix(ct, "image/"), strings.HasPrefix(ct, "audio/"), strings.HasPrefix(ct, "video/"):
+		// svg is text
+		return ct != "image/svg+xml"
+	}
+	return compressedTypes[ct]
+}
+
+// worthCompressing guesses from the object name, its content type and the
+// first bytes whether the content is compressed already.
+func worthCompressing(msg *message.MinioMessage) bool {
+	if len(msg.GetContent()) < minSize {
+		return false
+	}
+	if compressedExts[strings.ToLower(path.Ext(msg.GetName()))] {
+		return false
+	}
+	if compressedType(msg.GetMeta().GetContentType()) || msg.GetMeta().GetContentEncoding() != "" {
+		// a content encoding like gzip or br compressed it already
+		return false
+	}
+	return !compressedType(http.DetectContentType(msg.GetContent()))
+}
+
+// Compress compresses the content of msg in place, unless compression is
+// off, the content looks compressed already or doesn't get smaller.
+func Compress(msg *message.MinioMessage) {
+	if level == 0 || msg.GetCompression() != message.Compression_NONE || !worthCompressing(msg) {
+		return
+	}
+	once.Do(initCodec)
+	cont := encoder.EncodeAll(msg.GetContent(), nil)
+	if len(cont) >= len(msg.GetContent()) {
+		return
+	}
+	msg.Content = cont
+	msg.Compression = message.Compression_ZSTD
+}
+
+// Decompress restores the content of a msg compressed by Compress.
+func Decompress(msg *message.MinioMessage) error {
+	switch msg.GetCompression() {
+	case message.Compression_NONE:
+		return nil
+	case message.Compression_ZSTD:
+	default:
+		return fmt.Errorf("unknown compression %s", msg.GetCompression().String())
+	}
+	once.Do(initCodec)
+	cont, err := decoder.DecodeAll(msg.GetContent(), nil)
+	if err != nil {
+		return err
+	}
+	msg.Content = cont
+	msg.Compression = message.Compression_NONE
+	return nil
+}
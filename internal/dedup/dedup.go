@@ -0,0 +1,251 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/atomicfile"
+)
+
+// span is an inclusive range of sequence numbers.
+type span struct {
+	lo, hi uint64
+}
+
+// session is what a Store knows of one client session: every seq up to high
+// is applied, except the ones in holes. Holes are left by messages that
+// failed, were dropped by the client or are still to be resent, and by seqs
+// the client reserved but didn't use before a restart. They are kept until
+// the client's floor says it won't send them anymore.
+type session struct {
+	high  uint64
+	holes []span // 按 lo 排序, 互不重叠
+}
+
+func (s *session) applied(seq uint64) bool {
+	if seq > s.high {
+		return false
+	}
+	i := sort.Search(len(s.holes), func(i int) bool { return s.holes[i].hi >= seq })
+	return i == len(s.holes) || s.holes[i].lo > seq
+}
+
+func (s *session) mark(seq uint64) {
+	if seq > s.high {
+		if seq > s.high+1 {
+			s.holes = append(s.holes, span{s.high + 1, seq - 1})
+		}
+		s.high = seq
+		return
+	}
+	i := sort.Search(len(s.holes), func(i int) bool { return s.holes[i].hi >= seq })
+	if i == len(s.holes) || s.holes[i].lo > seq {
+		return
+	}
+	h := s.holes[i]
+	switch {
+	case h.lo == h.hi:
+		s.holes = append(s.holes[:i], s.holes[i+1:]...)
+	case seq == h.lo:
+		s.holes[i].lo++
+	case seq == h.hi:
+		s.holes[i].hi--
+	default:
+		s.holes[i].hi = seq - 1
+		s.holes = append(s.holes[:i+1], append([]span{{seq + 1, h.hi}}, s.holes[i+1:]...)...)
+	}
+}
+
+// forget drops the holes below floor, the client acked or dropped every seq
+// below it.
+func (s *session) forget(floor uint64) {
+	i := sort.Search(len(s.holes), func(i int) bool { return s.holes[i].hi >= floor })
+	s.holes = append(s.holes[:0], s.holes[i:]...)
+	if len(s.holes) > 0 && s.holes[0].lo < floor {
+		s.holes[0].lo = floor
+	}
+}
+
+// batch is a group of marks written to disk together.
+type batch struct {
+	done bool
+	err  error
+}
+
+// Store records the sequence numbers applied per client session on disk, so
+// messages resent after a reconnect or a restart of either side are applied
+// only once.
+//
+// Every session is a small file in the store's directory. Marks made while
+// the sessions are written are written together afterwards, so concurrent
+// workers share the fsyncs. A crash between applying a message and recording
+// it applies the message a second time.
+type Store struct {
+	dir      string
+	lock     sync.Mutex
+	cond     *sync.Cond
+	sessions map[string]*session
+	dirty    map[string]bool // 改了还没写的 session
+	pending  *batch          // 下一次写入的 mark
+	flushing bool
+}
+
+// Open loads the sessions recorded in dir, creating it if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	st := &Store{dir: dir, sessions: make(map[string]*session), dirty: make(map[string]bool), pending: &batch{}}
+	st.cond = sync.NewCond(&st.lock)
+	for _, f := range files {
+		if f.IsDir() || !validSession(f.Name()) {
+			continue
+		}
+		s, err := load(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		st.sessions[f.Name()] = s
+	}
+	return st, nil
+}
+
+// Applied reports whether seq of the session was applied already. Messages
+// without a session, from clients that don't number them, never are.
+func (st *Store) Applied(sess string, seq uint64) bool {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	s := st.sessions[sess]
+	return s != nil && s.applied(seq)
+}
+
+// Mark records seq of the session as applied and forgets the holes below
+// floor, 0 if the client didn't send one. It returns once the mark is on disk.
+func (st *Store) Mark(sess string, seq, floor uint64) error {
+	if sess == "" {
+		return nil
+	}
+	if !validSession(sess) {
+		return fmt.Errorf("invalid session %q", sess)
+	}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	s := st.sessions[sess]
+	if s == nil {
+		log.Printf("new client session(%s) at seq(%d)\n", sess, seq)
+		s = &session{}
+		st.sessions[sess] = s
+	}
+	s.mark(seq)
+	if floor != 0 {
+		s.forget(floor)
+	}
+	st.dirty[sess] = true
+	b := st.pending
+	for !b.done {
+		if st.flushing {
+			st.cond.Wait()
+			continue
+		}
+		st.flush()
+	}
+	return b.err
+}
+
+// flush writes the dirty sessions of the pending batch, st.lock is released
+// while writing so the next batch can gather.
+func (st *Store) flush() {
+	st.flushing = true
+	b := st.pending
+	st.pending = &batch{}
+	data := make(map[string][]byte, len(st.dirty))
+	for sess := range st.dirty {
+		data[sess] = st.sessions[sess].encode()
+	}
+	st.dirty = make(map[string]bool)
+	st.lock.Unlock()
+
+	var err error
+	var failed []string
+	for sess, d := range data {
+		if werr := atomicfile.Write(filepath.Join(st.dir, sess), d); werr != nil {
+			err = werr
+			failed = append(failed, sess)
+		}
+	}
+
+	st.lock.Lock()
+	for _, sess := range failed {
+		// written again with the next batch
+		st.dirty[sess] = true
+	}
+	b.done, b.err = true, err
+	st.flushing = false
+	st.cond.Broadcast()
+}
+
+// validSession keeps session ids, which come from the client, usable as
+// file names.
+func validSession(s string) bool {
+	if s == "" || len(s) > 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// load reads a session file: the highest applied seq on the first line,
+// followed by one "lo hi" line per hole.
+func load(path string) (*session, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := &session{}
+	sc := bufio.NewScanner(f)
+	for first := true; sc.Scan(); first = false {
+		fields := strings.Fields(sc.Text())
+		if first && len(fields) == 1 {
+			if s.high, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			continue
+		}
+		if first || len(fields) != 2 {
+			return nil, fmt.Errorf("%s: invalid line %q", path, sc.Text())
+		}
+		var h span
+		if h.lo, err = strconv.ParseUint(fields[0], 10, 64); err == nil {
+			h.hi, err = strconv.ParseUint(fields[1], 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		s.holes = append(s.holes, h)
+	}
+	return s, sc.Err()
+}
+
+// encode returns the session in the format read by load.
+func (s *session) encode() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d\n", s.high)
+	for _, h := range s.holes {
+		fmt.Fprintf(&b, "%d %d\n", h.lo, h.hi)
+	}
+	return b.Bytes()
+}
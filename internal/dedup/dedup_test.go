@@ -0,0 +1,153 @@
+package dedup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSessionMark(t *testing.T) {
+	tests := []struct {
+		name  string
+		start session
+		marks []uint64
+		want  session
+	}{
+		{"in order", session{}, []uint64{1, 2, 3}, session{high: 3}},
+		{"above high leaves a hole", session{high: 2}, []uint64{6}, session{high: 6, holes: []span{{3, 5}}}},
+		{"next to high", session{high: 2}, []uint64{3}, session{high: 3}},
+		{"fill lo of a hole", session{high: 6, holes: []span{{3, 5}}}, []uint64{3}, session{high: 6, holes: []span{{4, 5}}}},
+		{"fill hi of a hole", session{high: 6, holes: []span{{3, 5}}}, []uint64{5}, session{high: 6, holes: []span{{3, 4}}}},
+		{"split a hole", session{high: 6, holes: []span{{2, 5}}}, []uint64{3}, session{high: 6, holes: []span{{2, 2}, {4, 5}}}},
+		{"fill a single seq hole", session{high: 6, holes: []span{{1, 1}, {3, 3}, {5, 5}}}, []uint64{3}, session{high: 6, holes: []span{{1, 1}, {5, 5}}}},
+		{"fill a hole completely", session{high: 6, holes: []span{{3, 5}}}, []uint64{4, 3, 5}, session{high: 6}},
+		{"applied again", session{high: 6, holes: []span{{3, 4}}}, []uint64{2, 6, 5}, session{high: 6, holes: []span{{3, 4}}}},
+		{"duplicate above high", session{}, []uint64{4, 4}, session{high: 4, holes: []span{{1, 3}}}},
+		{"holes stay sorted", session{high: 2}, []uint64{5, 9, 7}, session{high: 9, holes: []span{{3, 4}, {6, 6}, {8, 8}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.start
+			for _, seq := range tt.marks {
+				s.mark(seq)
+			}
+			if !equal(&s, &tt.want) {
+				t.Fatalf("got %+v, want %+v", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionApplied(t *testing.T) {
+	s := session{high: 9, holes: []span{{3, 4}, {7, 7}}}
+	for seq, want := range map[uint64]bool{1: true, 2: true, 3: false, 4: false, 5: true, 7: false, 8: true, 9: true, 10: false} {
+		if got := s.applied(seq); got != want {
+			t.Errorf("applied(%d) = %t, want %t", seq, got, want)
+		}
+	}
+}
+
+func TestSessionForget(t *testing.T) {
+	holes := []span{{3, 4}, {7, 9}, {12, 12}}
+	tests := []struct {
+		name  string
+		floor uint64
+		want  []span
+	}{
+		{"below all holes", 2, []span{{3, 4}, {7, 9}, {12, 12}}},
+		{"at lo of a hole", 3, []span{{3, 4}, {7, 9}, {12, 12}}},
+		{"inside a hole", 8, []span{{8, 9}, {12, 12}}},
+		{"at hi of a hole", 9, []span{{9, 9}, {12, 12}}},
+		{"between holes", 5, []span{{7, 9}, {12, 12}}},
+		{"above all holes", 13, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := session{high: 15, holes: append([]span(nil), holes...)}
+			s.forget(tt.floor)
+			if want := (session{high: 15, holes: tt.want}); !equal(&s, &want) {
+				t.Fatalf("got %+v, want %+v", s, want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    session
+		wantErr bool
+	}{
+		{"empty", "", session{}, false},
+		{"high only", "42\n", session{high: 42}, false},
+		{"holes", "42\n3 4\n7 7\n", session{high: 42, holes: []span{{3, 4}, {7, 7}}}, false},
+		{"hole first", "3 4\n", session{}, true},
+		{"two highs", "42\n43\n", session{}, true},
+		{"bad high", "x\n", session{}, true},
+		{"bad hole", "42\n3 x\n", session{}, true},
+		{"hole with three fields", "42\n3 4 5\n", session{}, true},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "s")
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			s, err := load(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("loaded %+v, want an error", s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equal(s, &tt.want) {
+				t.Fatalf("got %+v, want %+v", *s, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	st, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seq := range []uint64{1, 2, 5, 8} {
+		if err := st.Mark("ab01", seq, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Mark("ab01", 9, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Mark("bad session", 1, 0); err == nil {
+		t.Fatal("marked an invalid session")
+	}
+
+	st, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &session{high: 9, holes: []span{{4, 4}, {6, 7}}}
+	if got := st.sessions["ab01"]; got == nil || !equal(got, want) {
+		t.Fatalf("reopened %+v, want %+v", got, want)
+	}
+	if st.Applied("ab01", 6) || !st.Applied("ab01", 5) || st.Applied("", 1) {
+		t.Fatal("reopened store reports wrong applied seqs")
+	}
+}
+
+// equal compares sessions treating nil and empty holes the same.
+func equal(a, b *session) bool {
+	if len(a.holes) == 0 && len(b.holes) == 0 {
+		return a.high == b.high
+	}
+	return a.high == b.high && reflect.DeepEqual(a.holes, b.holes)
+}
@@ -0,0 +1,109 @@
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/message"
+)
+
+// Keyring encrypts message bodies end to end with XChaCha20-Poly1305,
+// independent of the transport.
+//
+// The key file has one key per line, "<key id>:<base64 of 32 bytes>". Seal
+// uses the first key, Open any key of the file, so keys are rotated by
+// adding the new key on the server, moving it to the top on the clients and
+// finally removing the old one.
+type Keyring struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// Load reads the keyring from path.
+func Load(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{aeads: make(map[string]cipher.AEAD)}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, b64, ok := strings.Cut(line, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("%s:%d: want <key id>:<base64 key>", path, n)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		aead, err := chacha20poly1305.NewX(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if _, dup := k.aeads[id]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate key id %s", path, n, id)
+		}
+		k.aeads[id] = aead
+		if k.current == "" {
+			k.current = id
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if k.current == "" {
+		return nil, fmt.Errorf("%s: no key", path)
+	}
+	return k, nil
+}
+
+// Seal encrypts plain with the current key into a marshaled message.Sealed.
+func (k *Keyring) Seal(plain []byte) ([]byte, error) {
+	aead := k.aeads[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&message.Sealed{
+		KeyId:      k.current,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plain, []byte(k.current)),
+	})
+}
+
+// SealedSize returns the size of a body of n bytes once sealed by Seal.
+func (k *Keyring) SealedSize(n int) int {
+	aead := k.aeads[k.current]
+	// key id, nonce and ciphertext with their tags
+	return 3 + protowire.SizeBytes(len(k.current)) + protowire.SizeBytes(aead.NonceSize()) + protowire.SizeBytes(n+aead.Overhead())
+}
+
+// Open decrypts a body sealed by Seal with any key of the keyring.
+func (k *Keyring) Open(body []byte) ([]byte, error) {
+	sealed := &message.Sealed{}
+	if err := proto.Unmarshal(body, sealed); err != nil {
+		return nil, err
+	}
+	aead := k.aeads[sealed.GetKeyId()]
+	if aead == nil {
+		return nil, fmt.Errorf("unknown key id %q", sealed.GetKeyId())
+	}
+	if len(sealed.GetNonce()) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	return aead.Open(nil, sealed.GetNonce(), sealed.GetCiphertext(), []byte(sealed.GetKeyId()))
+}
@@ -1,30 +1,82 @@
 package idgenerator
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/atomicfile"
 )
 
+// reserveBlock is how many ids are reserved on disk at once. Ids reserved but
+// not used before a restart are skipped, so ids never repeat.
+const reserveBlock = 1024
+
+// IdGenerator hands out monotonically increasing 64-bit sequence numbers,
+// scoped to a session id. Both survive restarts in a small state file.
 type IdGenerator struct {
-	i    int32
-	lock sync.Mutex
+	path    string
+	session string
+	next    uint64
+	limit   uint64 // 已经持久化的最大 id
+	lock    sync.Mutex
 }
 
-func (id *IdGenerator) Get() int32 {
+// Open loads the generator state from path, creating a new session if the
+// file doesn't exist.
+func Open(path string) (*IdGenerator, error) {
+	id := &IdGenerator{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		id.session = hex.EncodeToString(b)
+		id.next = 1
+		return id, id.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("%s: invalid state %q", path, data)
+	}
+	id.session = fields[0]
+	if id.limit, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	id.next = id.limit + 1
+	return id, nil
+}
+
+// Session returns the id of the session the sequence numbers belong to.
+func (id *IdGenerator) Session() string {
+	return id.session
+}
+
+// Get returns the next sequence number.
+func (id *IdGenerator) Get() (uint64, error) {
 	id.lock.Lock()
 	defer id.lock.Unlock()
-	id.i = id.i + 1
-	if id.i == 128 {
-		id.i = 1
+	if id.next > id.limit {
+		id.limit = id.next + reserveBlock - 1
+		if err := id.save(); err != nil {
+			id.limit = id.next - 1
+			return 0, err
+		}
 	}
-	return id.i
+	n := id.next
+	id.next++
+	return n, nil
 }
 
-var instance *IdGenerator
-var once sync.Once
-
-func GetInstance() *IdGenerator {
-	once.Do(func() {
-		instance = &IdGenerator{}
-	})
-	return instance
+// save persists session and limit.
+func (id *IdGenerator) save() error {
+	return atomicfile.Write(id.path, []byte(fmt.Sprintf("%s %d\n", id.session, id.limit)))
 }
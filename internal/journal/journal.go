@@ -0,0 +1,204 @@
+package journal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/atomicfile"
+	"github.com/yimiaoxiehou/minio-sync/internal/message"
+)
+
+const entrySuffix = ".msg"
+
+// Journal is a write-ahead spool of outbound messages kept on local disk.
+//
+// Every message is written to its own file before it is sent and the file is
+// only removed once the server acknowledged it, so entries left in the
+// directory after a crash are sent again on the next start.
+type Journal struct {
+	dir    string
+	lock   sync.Mutex
+	cond   *sync.Cond
+	nextId uint64
+	queue  []uint64          // 等待发送的 entry id, 按写入顺序
+	live   map[uint64]uint64 // 还在磁盘上的 entry 和它的 seq, 还没读过的是 0
+	ids    []uint64          // live 的 id 按大小排序, 删掉的在 Floor 里清理
+}
+
+// Open opens the journal in dir, creating it if needed, and queues every
+// entry left over from a previous run for sending.
+func Open(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{dir: dir, nextId: 1, live: make(map[uint64]uint64)}
+	j.cond = sync.NewCond(&j.lock)
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, entrySuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, entrySuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		j.queue = append(j.queue, id)
+		j.live[id] = 0
+		if id >= j.nextId {
+			j.nextId = id + 1
+		}
+	}
+	sort.Slice(j.queue, func(a, b int) bool { return j.queue[a] < j.queue[b] })
+	j.ids = append(j.ids, j.queue...)
+	return j, nil
+}
+
+// Append durably writes msg to the journal and queues it for sending.
+func (j *Journal) Append(msg *message.MinioMessage) (uint64, error) {
+	ids, err := j.AppendAll([]*message.MinioMessage{msg})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// AppendAll durably writes msgs to the journal and queues them for sending
+// in order. Their files are written together, sharing the sync of the
+// directory.
+func (j *Journal) AppendAll(msgs []*message.MinioMessage) ([]uint64, error) {
+	paths := make([]string, len(msgs))
+	data := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		var err error
+		if data[i], err = proto.Marshal(msg); err != nil {
+			return nil, err
+		}
+	}
+	ids := make([]uint64, len(msgs))
+	j.lock.Lock()
+	for i, msg := range msgs {
+		ids[i] = j.nextId
+		j.nextId++
+		paths[i] = j.path(ids[i])
+		// Floor must not pass an entry still being written
+		j.live[ids[i]] = msg.GetSeq()
+		j.ids = append(j.ids, ids[i])
+	}
+	j.lock.Unlock()
+
+	if err := atomicfile.WriteAll(paths, data); err != nil {
+		j.lock.Lock()
+		for _, id := range ids {
+			delete(j.live, id)
+		}
+		j.lock.Unlock()
+		return nil, err
+	}
+
+	j.lock.Lock()
+	j.queue = append(j.queue, ids...)
+	j.lock.Unlock()
+	j.cond.Signal()
+	return ids, nil
+}
+
+// Next blocks until an entry is waiting to be sent and returns it.
+// The entry stays on disk until Remove is called.
+func (j *Journal) Next() (uint64, *message.MinioMessage, error) {
+	var id uint64
+	var data []byte
+	for {
+		j.lock.Lock()
+		for len(j.queue) == 0 {
+			j.cond.Wait()
+		}
+		id = j.queue[0]
+		j.queue = j.queue[1:]
+		j.lock.Unlock()
+
+		var err error
+		data, err = os.ReadFile(j.path(id))
+		if os.IsNotExist(err) {
+			// requeued entry that was acked in the meantime
+			continue
+		}
+		if err != nil {
+			return id, nil, err
+		}
+		break
+	}
+	msg := &message.MinioMessage{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return id, nil, fmt.Errorf("journal entry %d: %w", id, err)
+	}
+	j.lock.Lock()
+	if _, ok := j.live[id]; ok {
+		j.live[id] = msg.GetSeq()
+	}
+	j.lock.Unlock()
+	return id, msg, nil
+}
+
+// Requeue puts entries returned by Next back in front of the queue, e.g.
+// because the connection they were sent on broke before they were acked.
+func (j *Journal) Requeue(ids ...uint64) {
+	if len(ids) == 0 {
+		return
+	}
+	j.lock.Lock()
+	j.queue = append(ids, j.queue...)
+	sort.Slice(j.queue, func(a, b int) bool { return j.queue[a] < j.queue[b] })
+	j.lock.Unlock()
+	j.cond.Signal()
+}
+
+// Remove deletes an acknowledged entry from the journal.
+func (j *Journal) Remove(id uint64) error {
+	err := os.Remove(j.path(id))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	if err == nil {
+		j.lock.Lock()
+		delete(j.live, id)
+		j.lock.Unlock()
+	}
+	return err
+}
+
+// Floor returns the seq of the oldest entry still on disk, 0 if it's not
+// known yet. Seqs are stamped in the order entries are appended, so every
+// seq below it was acked or dropped.
+func (j *Journal) Floor() uint64 {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for len(j.ids) > 0 {
+		if seq, ok := j.live[j.ids[0]]; ok {
+			return seq
+		}
+		j.ids = j.ids[1:]
+	}
+	return 0
+}
+
+// Len returns the number of entries waiting to be sent.
+func (j *Journal) Len() int {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return len(j.queue)
+}
+
+func (j *Journal) path(id uint64) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%020d%s", id, entrySuffix))
+}
@@ -0,0 +1,217 @@
+package minio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"github.com/yimiaoxiehou/minio-sync/internal/message"
+	"google.golang.org/protobuf/proto"
+)
+
+// sourceEtagKey is the user metadata the target object keeps the etag of the
+// source object in. An object put as multipart upload gets another etag than
+// the source, which is needed to tell whether it changed.
+const sourceEtagKey = "Source-Etag"
+
+// sourceEtag returns the source etag in user metadata m, "" if there is none.
+// Listings of minio keep the x-amz-meta- prefix of the keys, stat drops it.
+func sourceEtag(m map[string]string) string {
+	for k, v := range m {
+		if len(k) >= len("X-Amz-Meta-") && strings.EqualFold(k[:len("X-Amz-Meta-")], "X-Amz-Meta-") {
+			k = k[len("X-Amz-Meta-"):]
+		}
+		if strings.EqualFold(k, sourceEtagKey) {
+			return v
+		}
+	}
+	return ""
+}
+
+// targetEtag returns the etag of the source object the target object info
+// was created from.
+func targetEtag(info minio.ObjectInfo) string {
+	if etag := sourceEtag(info.UserMetadata); etag != "" {
+		return etag
+	}
+	return info.ETag
+}
+
+// metaDigest sums the metadata and tags of obj as listed with metadata, which
+// minio does for the source and the target alike. It is "" if the listing
+// has none, e.g. of another S3 server.
+func metaDigest(obj minio.ObjectInfo) string {
+	if obj.UserMetadata == nil {
+		return ""
+	}
+	var fields []string
+	for k, v := range obj.UserMetadata {
+		k = strings.ToLower(k)
+		switch {
+		case k == "content-type", k == "content-encoding", k == "content-disposition", k == "content-language", k == "cache-control":
+		case k == "expires":
+			// the target gets it formatted by minio-go
+			if t, err := http.ParseTime(v); err == nil {
+				v = strconv.FormatInt(t.Unix(), 10)
+			}
+		case strings.HasPrefix(k, "x-amz-meta-"):
+			if strings.EqualFold(k[len("x-amz-meta-"):], sourceEtagKey) {
+				continue
+			}
+		default:
+			// internal or not replicated
+			continue
+		}
+		fields = append(fields, k+"\x00"+v)
+	}
+	for k, v := range obj.UserTags {
+		fields = append(fields, "tag:"+k+"\x00"+v)
+	}
+	sort.Strings(fields)
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// objectMeta returns the metadata of info the target object is created with.
+func objectMeta(info minio.ObjectInfo) *message.ObjectMeta {
+	meta := &message.ObjectMeta{
+		ContentType:        info.ContentType,
+		ContentEncoding:    info.Metadata.Get("Content-Encoding"),
+		ContentDisposition: info.Metadata.Get("Content-Disposition"),
+		ContentLanguage:    info.Metadata.Get("Content-Language"),
+		CacheControl:       info.Metadata.Get("Cache-Control"),
+	}
+	if !info.Expires.IsZero() {
+		meta.Expires = info.Expires.Unix()
+	}
+	for k, v := range info.UserMetadata {
+		if strings.EqualFold(k, sourceEtagKey) {
+			// set by putOptions
+			continue
+		}
+		if meta.UserMetadata == nil {
+			meta.UserMetadata = make(map[string]string)
+		}
+		meta.UserMetadata[k] = v
+	}
+	return meta
+}
+
+// sourceMeta returns the metadata of the source object key including its
+// tags, which info doesn't carry.
+func sourceMeta(bucket, key string, info minio.ObjectInfo) (*message.ObjectMeta, error) {
+	meta := objectMeta(info)
+	var err error
+	meta.Tags, err = objectTags(bucket, key, info)
+	return meta, err
+}
+
+// objectTags returns the tags of the object key described by info.
+func objectTags(bucket, key string, info minio.ObjectInfo) (map[string]string, error) {
+	if info.UserTagCount == 0 {
+		return nil, nil
+	}
+	t, err := mClient.GetObjectTagging(context.Background(), bucket, key, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return t.ToMap(), nil
+}
+
+// setTags replaces the tags of the target object, removes them if there are
+// none.
+func setTags(bucket, key string, m map[string]string) error {
+	if len(m) == 0 {
+		return mClient.RemoveObjectTagging(context.Background(), bucket, key, minio.RemoveObjectTaggingOptions{})
+	}
+	t, err := tags.NewTags(m, true)
+	if err != nil {
+		return err
+	}
+	return mClient.PutObjectTagging(context.Background(), bucket, key, t, minio.PutObjectTaggingOptions{})
+}
+
+// syncTags gives the target object info, which is the object of msg, the
+// tags of msg. Nothing is transferred but the tags.
+func syncTags(info minio.ObjectInfo, msg *message.MinioMessage) error {
+	if msg.GetMeta() == nil {
+		return nil
+	}
+	current, err := objectTags(msg.GetBucket(), msg.GetName(), info)
+	if err != nil {
+		return err
+	}
+	if maps.Equal(current, msg.GetMeta().GetTags()) {
+		return nil
+	}
+	return setTags(msg.GetBucket(), msg.GetName(), msg.GetMeta().GetTags())
+}
+
+// taggingMessage returns the message replaying a change of the tags of the
+// source object key. The tags are read by ResolveTagging when it is sent, so
+// minio failing to tell them now doesn't lose the change.
+func taggingMessage(bucket, key string) *message.MinioMessage {
+	return &message.MinioMessage{
+		Type:   message.MessageType_S3_Object_Tagging,
+		Bucket: bucket,
+		Name:   key,
+	}
+}
+
+// ResolveTagging fills in the current tags of the object of a
+// S3_Object_Tagging message without them.
+func ResolveTagging(msg *message.MinioMessage) error {
+	t, err := mClient.GetObjectTagging(context.Background(), msg.GetBucket(), msg.GetName(), minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return err
+	}
+	msg.Meta = &message.ObjectMeta{Tags: t.ToMap()}
+	return nil
+}
+
+// putOptions returns the options creating an object with meta, which keeps
+// etag, the etag of the source object.
+func putOptions(meta *message.ObjectMeta, etag string) minio.PutObjectOptions {
+	opts := minio.PutObjectOptions{
+		ContentType:        meta.GetContentType(),
+		ContentEncoding:    meta.GetContentEncoding(),
+		ContentDisposition: meta.GetContentDisposition(),
+		ContentLanguage:    meta.GetContentLanguage(),
+		CacheControl:       meta.GetCacheControl(),
+		UserMetadata:       maps.Clone(meta.GetUserMetadata()),
+		UserTags:           meta.GetTags(),
+	}
+	if etag != "" {
+		if opts.UserMetadata == nil {
+			opts.UserMetadata = make(map[string]string)
+		}
+		opts.UserMetadata[sourceEtagKey] = etag
+	}
+	if meta.GetExpires() != 0 {
+		opts.Expires = time.Unix(meta.GetExpires(), 0)
+	}
+	return opts
+}
+
+// sameObject reports whether the target object info already is the object of
+// msg. Messages of clients that don't send metadata only compare the etag.
+func sameObject(info minio.ObjectInfo, msg *message.MinioMessage) bool {
+	if targetEtag(info) != msg.GetEtag() {
+		return false
+	}
+	if msg.GetMeta() == nil {
+		return true
+	}
+	// 内容一样元数据也可能改了, 比如原地 copy 替换元数据. tags 由 syncTags 单独更新
+	meta := proto.Clone(msg.GetMeta()).(*message.ObjectMeta)
+	meta.Tags = nil
+	return proto.Equal(objectMeta(info), meta)
+}
@@ -3,23 +3,28 @@ package minio
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/minio/madmin-go/v3"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/notification"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
-	idgenerator "github.com/yimiaoxiehou/minio-sync/internal/id_generator"
+	"github.com/yimiaoxiehou/minio-sync/internal/checkpoint"
 	"github.com/yimiaoxiehou/minio-sync/internal/message"
 )
 
 var aClient *madmin.AdminClient
 var mClient *minio.Client
+var mCore *minio.Core
 
 func InitMinioClient(minioAddress, minioUsername, minioPassword string) {
 	log.Printf("Connect minio address(%s) username(%s)\n", minioAddress, minioUsername)
@@ -34,6 +39,7 @@ func InitMinioClient(minioAddress, minioUsername, minioPassword string) {
 		Secure: false,
 	})
 	logErr(err)
+	mCore = &minio.Core{Client: mClient}
 	_, err = mClient.HealthCheck(time.Second * 3)
 	logErr(err)
 	log.Printf("Connected minio address(%s) username(%s)\n", minioAddress, minioUsername)
@@ -51,23 +57,43 @@ func ProcessMinioEvent(msg *message.MinioMessage) error {
 	case message.MessageType_S3_Object_Put.Number():
 		obj, err := mClient.StatObject(context.Background(), msg.GetBucket(), msg.GetName(), minio.StatObjectOptions{})
 		// obj exist and accessable
-		if err == nil && obj.ETag == msg.GetEtag() {
-			return nil
+		if err == nil && sameObject(obj, msg) {
+			return syncTags(obj, msg)
 		}
 		read := bytes.NewReader(msg.GetContent())
-		_, err = mClient.PutObject(context.Background(), msg.GetBucket(), msg.GetName(), read, int64(len(msg.GetContent())), minio.PutObjectOptions{})
+		_, err = mClient.PutObject(context.Background(), msg.GetBucket(), msg.GetName(), read, int64(len(msg.GetContent())), putOptions(msg.GetMeta(), msg.GetEtag()))
 		return err
 	case message.MessageType_S3_Obejct_Delete.Number():
 		return mClient.RemoveObject(context.Background(), msg.GetBucket(), msg.GetName(), minio.RemoveObjectOptions{})
+	case message.MessageType_S3_Object_Tagging.Number():
+		if msg.GetMeta() == nil {
+			// without meta it would remove the tags
+			return fmt.Errorf("tagging of %s/%s without tags", msg.GetBucket(), msg.GetName())
+		}
+		err := setTags(msg.GetBucket(), msg.GetName(), msg.GetMeta().GetTags())
+		if IsNotFound(err) {
+			// 对象还没同步过来, 创建时会带上 tags
+			return nil
+		}
+		return err
+	case message.MessageType_S3_Object_Put_Begin.Number():
+		_, err := BeginTransfer(msg)
+		return err
+	case message.MessageType_S3_Object_Put_Chunk.Number():
+		return writeChunk(msg)
+	case message.MessageType_S3_Object_Put_Commit.Number():
+		return commitTransfer(msg)
 	}
 	return nil
 }
 
-func ListenMinioBucketEvent(skipBuckets []string, reqBuffer chan *message.MinioMessage) {
+// ListenMinioBucketEvent sends the messages replaying the bucket events.
+// captured is called with the time of every event once its message is sent.
+func ListenMinioBucketEvent(skipBuckets []string, reqBuffer chan *message.MinioMessage, captured func(t time.Time)) {
 	// Listen for Minio bucket notifications and handle events
 	for notificationInfo := range mClient.ListenNotification(context.Background(), "", "", []string{
-		"s3:ObjectCreated:Put",
-		"s3:ObjectRemoved:Delete",
+		string(notification.ObjectCreatedAll),
+		string(notification.ObjectRemovedAll),
 	}) {
 		if notificationInfo.Err != nil {
 			log.Fatalln(notificationInfo.Err)
@@ -76,36 +102,137 @@ func ListenMinioBucketEvent(skipBuckets []string, reqBuffer chan *message.MinioM
 			continue
 		}
 		for _, record := range notificationInfo.Records {
-			if "s3:ObjectRemoved:Delete" == record.EventName {
-				msg := message.MinioMessage{
-					Seq:     idgenerator.GetInstance().Get(),
-					Type:    message.MessageType_S3_Obejct_Delete,
-					Bucket:  record.S3.Bucket.Name,
-					Name:    record.S3.Object.Key,
-					Etag:    record.S3.Object.ETag,
-					Content: nil,
-				}
-				reqBuffer <- &msg
+			events.touch(record.S3.Bucket.Name, record.S3.Object.Key)
+			if msg := eventMessage(record); msg != nil {
+				reqBuffer <- msg
 			}
-			if "s3:ObjectCreated:Put" == record.EventName {
-				obj, err := mClient.GetObject(context.Background(), record.S3.Bucket.Name, record.S3.Object.Key, minio.GetObjectOptions{})
-				logErr(err)
-				cont, err := io.ReadAll(obj)
-				logErr(err)
-				msg := message.MinioMessage{
-					Seq:     idgenerator.GetInstance().Get(),
-					Type:    message.MessageType_S3_Object_Put,
-					Bucket:  record.S3.Bucket.Name,
-					Name:    record.S3.Object.Key,
-					Etag:    record.S3.Object.ETag,
-					Content: cont,
-				}
-				reqBuffer <- &msg
+			// minio's clock, the one CatchUp compares with
+			if t, err := time.Parse(time.RFC3339Nano, record.EventTime); err == nil {
+				captured(t)
 			}
 		}
 	}
 }
 
+// eventTracker tells which objects had events while a full sync or catch-up
+// runs next to the listener. An object it read before an event must not be
+// sent after the event's message, e.g. it would come back after a delete.
+type eventTracker struct {
+	lock    sync.Mutex
+	readers int               // 正在运行的全量同步和 catch-up
+	seen    map[string]uint64 // 有过事件的对象和最后一个事件的序号
+	n       uint64
+}
+
+// events tracks the objects of the bucket events.
+var events = &eventTracker{seen: make(map[string]uint64)}
+
+func (e *eventTracker) start() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.readers++
+}
+
+func (e *eventTracker) stop() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.readers--; e.readers == 0 {
+		e.seen = make(map[string]uint64)
+	}
+}
+
+// touch records an event of the object key, the key is escaped like in the
+// event.
+func (e *eventTracker) touch(bk, key string) {
+	if k, err := url.QueryUnescape(key); err == nil {
+		key = k
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.readers > 0 {
+		e.n++
+		e.seen[bk+"/"+key] = e.n
+	}
+}
+
+// version returns the last event of the object key, to be passed to send.
+func (e *eventTracker) version(bk, key string) uint64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.seen[bk+"/"+key]
+}
+
+// send sends msg, read when the last event of its object was v, unless the
+// object had another event since. That event's message replaces it.
+func (e *eventTracker) send(reqBuffer chan *message.MinioMessage, v uint64, msg *message.MinioMessage) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.seen[msg.GetBucket()+"/"+msg.GetName()] != v {
+		return false
+	}
+	// under the lock, so the message of a later event is sent after it
+	reqBuffer <- msg
+	return true
+}
+
+// eventMessage returns the message replaying record on the target, nil if
+// there is nothing to replay.
+func eventMessage(record notification.Event) *message.MinioMessage {
+	bk, key := record.S3.Bucket.Name, record.S3.Object.Key
+	if k, err := url.QueryUnescape(key); err == nil {
+		// 事件里的 key 是转义过的
+		key = k
+	}
+	switch ev := notification.EventType(record.EventName); {
+	case ev == notification.ObjectCreatedPutTagging, ev == notification.ObjectCreatedDeleteTagging:
+		return taggingMessage(bk, key)
+	case ev == notification.ObjectCreatedPutRetention, ev == notification.ObjectCreatedPutLegalHold:
+		// retention and legal hold aren't replicated
+		return nil
+	case strings.HasPrefix(string(ev), "s3:ObjectCreated:"):
+		return createdMessage(bk, key, record.S3.Object.ETag, record.S3.Object.Size)
+	case strings.HasPrefix(string(ev), "s3:ObjectRemoved:"):
+		// 删除某个旧版本, 或者删除之后又创建了, 当前对象还在
+		exists, err := objectExists(bk, key)
+		if err != nil {
+			// most deletes remove the current object, losing one is worse
+			log.Printf("stat bucket(%s) name(%s) failed, send the delete: %v\n", bk, key, err)
+		} else if exists {
+			return nil
+		}
+		return &message.MinioMessage{
+			Type:    message.MessageType_S3_Obejct_Delete,
+			Bucket:  bk,
+			Name:    key,
+			Etag:    record.S3.Object.ETag,
+			Content: nil,
+		}
+	}
+	return nil
+}
+
+// statTries is how often objectExists stats an object before giving up.
+const statTries = 3
+
+// objectExists reports whether bk has the object key, trying again a few
+// times if minio can't tell.
+func objectExists(bk, key string) (bool, error) {
+	var err error
+	for i := 0; i < statTries; i++ {
+		if i > 0 {
+			time.Sleep(time.Second << (i - 1))
+		}
+		_, err = mClient.StatObject(context.Background(), bk, key, minio.StatObjectOptions{})
+		if err == nil {
+			return true, nil
+		}
+		if IsNotFound(err) {
+			return false, nil
+		}
+	}
+	return false, err
+}
+
 func ExpoortBucketMetadata(skipBuckets []string) []*message.MinioMessage {
 	buckets, err := mClient.ListBuckets(context.Background())
 	logErr(err)
@@ -119,7 +246,6 @@ func ExpoortBucketMetadata(skipBuckets []string) []*message.MinioMessage {
 		data, err := io.ReadAll(reader)
 		logErr(err)
 		msgs = append(msgs, &message.MinioMessage{
-			Seq:     idgenerator.GetInstance().Get(),
 			Type:    message.MessageType_Minio_BUCKETS_Export,
 			Bucket:  bucket.Name,
 			Name:    "",
@@ -147,36 +273,325 @@ func ExportIAM() *message.MinioMessage {
 	}
 }
 
-func ExportAllObject(reqBuffer chan *message.MinioMessage) {
+// ExportOptions configures ExportAllObject.
+type ExportOptions struct {
+	Readers       int                    // 并发读取对象的 goroutine 数
+	BucketReaders int                    // 其中同一个 bucket 最多几个
+	Checkpoint    *checkpoint.Checkpoint // 上次全量同步的进度
+	SkipBuckets   []string               // 不同步的 bucket
+	// Inventory returns the objects the target has in bucket after the key
+	// after, in key order. If nil every object is sent.
+	Inventory func(bucket, after string) (*message.Inventory, error)
+	Mirror    *MirrorOptions // 不为 nil 时删除目标端多出来的对象, 需要 Inventory
+}
+
+// MirrorOptions configures the deletion of objects only the target has.
+type MirrorOptions struct {
+	// MaxShare is the largest share of a bucket's objects on the target that
+	// is deleted, a bucket with more target-only objects is left alone.
+	MaxShare float64
+	DryRun   bool // 只打印要删除的对象
+}
+
+// ExportAllObject sends a put message for every object. Objects are read by
+// at most Readers goroutines, at most BucketReaders of them in the same
+// bucket, which bounds the memory to Readers objects of up to ChunkSize bytes.
+// As many buckets are listed at once as keep the readers busy.
+//
+// Each bucket continues after the last object the checkpoint says the server
+// acked. With an inventory only objects the target doesn't have with the same
+// etag, size, metadata and tags are sent. Mirror compares the objects before
+// that key again, the target-only ones among them are deleted too.
+func ExportAllObject(reqBuffer chan *message.MinioMessage, opts ExportOptions) {
 	log.Println("export all object.")
+	events.start()
+	defer events.stop()
+	cp := opts.Checkpoint
 	bks, err := mClient.ListBuckets(context.Background())
 	logErr(err)
-	for _, bk := range bks {
-		listBucketAllObj(bk.Name, "", reqBuffer)
+	bks = slices.DeleteFunc(bks, func(bk minio.BucketInfo) bool { return slices.Contains(opts.SkipBuckets, bk.Name) })
+	todo := startBuckets(bks, cp)
+	if len(todo) == 0 {
+		// the last full sync completed, start over
+		logErr(cp.Reset())
+		todo = startBuckets(bks, cp)
 	}
+	readers := make(chan struct{}, opts.Readers)
+	// 每个 bucket 的列举和它的 inventory 都占内存
+	listers := make(chan struct{}, max(1, opts.Readers/opts.BucketReaders))
+	var wg sync.WaitGroup
+	for bk, after := range todo {
+		wg.Add(1)
+		listers <- struct{}{}
+		go func(bk, after string) {
+			defer wg.Done()
+			defer func() { <-listers }()
+			if after != "" {
+				log.Printf("export bucket(%s) after key(%s).\n", bk, after)
+			}
+			var target *inventory
+			from := after
+			if opts.Inventory != nil {
+				target = &inventory{fetch: opts.Inventory, bucket: bk, after: after, more: true, mirror: opts.Mirror != nil}
+				if target.mirror {
+					// the target-only objects and the total span the whole bucket
+					target.after, from = "", ""
+				}
+			}
+			skipped := 0
+			bucketReaders := make(chan struct{}, opts.BucketReaders)
+			var bwg sync.WaitGroup
+			listBucketAllObj(bk, from, func(obj minio.ObjectInfo) {
+				if obj.Key <= after {
+					// synced before the restart, only tell mirror the source has it
+					target.find(obj.Key)
+					return
+				}
+				cp.Listed(bk, obj.Key)
+				if t := target.find(obj.Key); t != nil && sameListed(t, obj) {
+					// the target has it already
+					skipped++
+					logErr(cp.Acked(bk, obj.Key))
+					return
+				}
+				bucketReaders <- struct{}{}
+				readers <- struct{}{}
+				bwg.Add(1)
+				go func() {
+					defer bwg.Done()
+					v := events.version(bk, obj.Key)
+					msg := createdMessage(bk, obj.Key, obj.ETag, obj.Size)
+					if msg == nil || !events.send(reqBuffer, v, msg) {
+						// gone since it was listed, or changed and sent by the event
+						logErr(cp.Acked(bk, obj.Key))
+					}
+					<-readers
+					<-bucketReaders
+				}()
+			})
+			bwg.Wait()
+			if opts.Mirror != nil {
+				mirror(reqBuffer, target, opts.Mirror)
+			}
+			logErr(cp.Finish(bk))
+			log.Printf("export bucket(%s) done, skipped %d objects the target has.\n", bk, skipped)
+		}(bk, after)
+	}
+	wg.Wait()
 }
 
-func listBucketAllObj(bk, prefix string, reqBuffer chan *message.MinioMessage) {
-	for obj := range mClient.ListObjects(context.Background(), bk, minio.ListObjectsOptions{Prefix: prefix}) {
-		if obj.Size == 0 && strings.HasSuffix(obj.Key, string(os.PathSeparator)) {
-			listBucketAllObj(bk, obj.Key, reqBuffer)
+// inventory walks the objects the target has in a bucket, page by page.
+type inventory struct {
+	fetch  func(bucket, after string) (*message.Inventory, error)
+	bucket string
+	after  string // 最后拿到的 key
+	page   []*message.ObjectInfo
+	more   bool
+	failed bool // 没拿全, 不知道目标端还有什么
+	total  int  // 目标端的对象数
+	mirror bool
+	extra  []string // 只有目标端有的对象
+}
+
+// find returns the target's object named key, nil if it has none. Keys must
+// be looked up in order. If the inventory can't be fetched, nil is returned
+// for this and every later key, i.e. they are all sent.
+func (inv *inventory) find(key string) *message.ObjectInfo {
+	if inv == nil {
+		return nil
+	}
+	for {
+		for len(inv.page) > 0 && inv.page[0].GetName() < key {
+			inv.skip(inv.page[0])
+		}
+		if len(inv.page) > 0 {
+			if o := inv.page[0]; o.GetName() == key {
+				inv.page = inv.page[1:]
+				return o
+			}
+			return nil
+		}
+		if !inv.next() {
+			return nil
+		}
+	}
+}
+
+// finish walks the rest of the inventory, which the source doesn't have.
+func (inv *inventory) finish() {
+	for {
+		for len(inv.page) > 0 {
+			inv.skip(inv.page[0])
+		}
+		if !inv.next() {
+			return
+		}
+	}
+}
+
+// skip drops the first object of the page, which the source doesn't have.
+func (inv *inventory) skip(o *message.ObjectInfo) {
+	inv.page = inv.page[1:]
+	if inv.mirror && !(o.GetSize() == 0 && strings.HasSuffix(o.GetName(), "/")) {
+		// 目录不算
+		inv.extra = append(inv.extra, o.GetName())
+	}
+}
+
+// next fetches the next page, false if there is none.
+func (inv *inventory) next() bool {
+	if !inv.more {
+		return false
+	}
+	page, err := inv.fetch(inv.bucket, inv.after)
+	if err != nil {
+		log.Printf("fetch inventory of bucket(%s) after key(%s) failed, send everything: %v\n", inv.bucket, inv.after, err)
+		inv.more = false
+		inv.failed = true
+		return false
+	}
+	inv.page = page.GetObjects()
+	inv.total += len(inv.page)
+	inv.more = page.GetMore() && len(inv.page) > 0
+	if len(inv.page) > 0 {
+		inv.after = inv.page[len(inv.page)-1].GetName()
+	}
+	return true
+}
+
+// mirror deletes the objects only the target has, once the whole bucket was
+// compared.
+func mirror(reqBuffer chan *message.MinioMessage, target *inventory, opts *MirrorOptions) {
+	target.finish()
+	bk := target.bucket
+	if target.failed {
+		log.Printf("mirror bucket(%s): inventory incomplete, delete nothing\n", bk)
+		return
+	}
+	if len(target.extra) == 0 {
+		return
+	}
+	if float64(len(target.extra)) > opts.MaxShare*float64(target.total) {
+		log.Printf("mirror bucket(%s): %d of %d objects on the target aren't on the source, more than the threshold(%g), delete nothing\n",
+			bk, len(target.extra), target.total, opts.MaxShare)
+		for _, key := range target.extra {
+			log.Printf("mirror bucket(%s): target only key(%s)\n", bk, key)
+		}
+		return
+	}
+	deleted := 0
+	for _, key := range target.extra {
+		// it may have been created since it was listed
+		_, err := mClient.StatObject(context.Background(), bk, key, minio.StatObjectOptions{})
+		if !IsNotFound(err) {
 			continue
 		}
-		r, err := mClient.GetObject(context.Background(), bk, obj.Key, minio.GetObjectOptions{})
-		logErr(err)
-		cont, err := io.ReadAll(r)
-		if err != nil {
-			logErr(err)
+		deleted++
+		if opts.DryRun {
+			log.Printf("mirror bucket(%s): would delete key(%s)\n", bk, key)
+			continue
 		}
-		msg := message.MinioMessage{
-			Seq:     idgenerator.GetInstance().Get(),
-			Type:    message.MessageType_S3_Object_Put,
-			Bucket:  bk,
-			Name:    obj.Key,
-			Etag:    obj.ETag,
-			Content: cont,
+		reqBuffer <- &message.MinioMessage{
+			Type:   message.MessageType_S3_Obejct_Delete,
+			Bucket: bk,
+			Name:   key,
+		}
+	}
+	if opts.DryRun {
+		log.Printf("mirror bucket(%s): dry run, would delete %d of %d objects on the target\n", bk, deleted, target.total)
+		return
+	}
+	log.Printf("mirror bucket(%s): delete %d of %d objects on the target\n", bk, deleted, target.total)
+}
+
+// Inventory lists the objects of bucket after the key after, at most about
+// maxBytes of them. It is the server's side of ExportOptions.Inventory. The
+// etags are the ones of the source objects they were created from, as far as
+// minio lists the metadata.
+func Inventory(bucket, after string, maxBytes int) (*message.Inventory, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inv := &message.Inventory{}
+	size := 0
+	for obj := range mClient.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: true, StartAfter: after, WithMetadata: true}) {
+		if obj.Err != nil {
+			if minio.ToErrorResponse(obj.Err).Code == "NoSuchBucket" {
+				return inv, nil
+			}
+			return nil, obj.Err
+		}
+		// name, etag, digest and the field overhead
+		n := len(obj.Key) + len(obj.ETag) + 48
+		if size+n > maxBytes && len(inv.Objects) > 0 {
+			inv.More = true
+			break
+		}
+		size += n
+		inv.Objects = append(inv.Objects, &message.ObjectInfo{Name: obj.Key, Etag: targetEtag(obj), Size: obj.Size, MetaDigest: metaDigest(obj)})
+	}
+	return inv, nil
+}
+
+// sameListed reports whether the target object t is the source object obj.
+// The metadata is compared if both listings have it.
+func sameListed(t *message.ObjectInfo, obj minio.ObjectInfo) bool {
+	if t.GetEtag() != obj.ETag || t.GetSize() != obj.Size {
+		return false
+	}
+	d := metaDigest(obj)
+	return t.GetMetaDigest() == "" || d == "" || t.GetMetaDigest() == d
+}
+
+// CatchUp sends the objects modified since since, the changes missed while
+// the client was down. Deletes can't be listed, they are only caught up by a
+// full sync in mirror mode.
+func CatchUp(reqBuffer chan *message.MinioMessage, skipBuckets []string, since time.Time) {
+	log.Printf("export objects modified since %s.\n", since.Format(time.RFC3339))
+	events.start()
+	defer events.stop()
+	bks, err := mClient.ListBuckets(context.Background())
+	logErr(err)
+	for _, bk := range bks {
+		if slices.Contains(skipBuckets, bk.Name) {
+			continue
+		}
+		sent := 0
+		listBucketAllObj(bk.Name, "", func(obj minio.ObjectInfo) {
+			if obj.LastModified.Before(since) {
+				return
+			}
+			v := events.version(bk.Name, obj.Key)
+			if msg := createdMessage(bk.Name, obj.Key, obj.ETag, obj.Size); msg != nil && events.send(reqBuffer, v, msg) {
+				sent++
+			}
+		})
+		log.Printf("export bucket(%s) done, %d objects modified.\n", bk.Name, sent)
+	}
+}
+
+// startBuckets returns the buckets not synced yet, with the key to continue
+// after.
+func startBuckets(bks []minio.BucketInfo, cp *checkpoint.Checkpoint) map[string]string {
+	todo := make(map[string]string)
+	for _, bk := range bks {
+		if after, done := cp.Start(bk.Name); !done {
+			todo[bk.Name] = after
+		}
+	}
+	return todo
+}
+
+// listBucketAllObj lists the objects of bk in key order, starting after the
+// key after.
+func listBucketAllObj(bk, after string, found func(obj minio.ObjectInfo)) {
+	// with metadata for the inventory comparison
+	for obj := range mClient.ListObjects(context.Background(), bk, minio.ListObjectsOptions{Recursive: true, StartAfter: after, WithMetadata: true}) {
+		logErr(obj.Err)
+		if obj.Size == 0 && strings.HasSuffix(obj.Key, string(os.PathSeparator)) {
+			// 目录
+			continue
 		}
-		reqBuffer <- &msg
+		found(obj)
 	}
 }
 
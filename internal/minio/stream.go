@@ -0,0 +1,536 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/yimiaoxiehou/minio-sync/internal/atomicfile"
+	"github.com/yimiaoxiehou/minio-sync/internal/message"
+	"google.golang.org/protobuf/proto"
+)
+
+// ChunkSize is the largest object sent inline in a single message, bigger
+// objects are streamed as begin / chunk / commit messages of at most this size.
+const ChunkSize = 4 << 20
+
+// partSize is the multipart part size used by the server while streaming, it
+// bounds the memory of a transfer.
+const partSize = 16 << 20
+
+// objectPutMessage builds the message for a created object. Small objects carry
+// their content, large ones only a S3_Object_Put_Begin marker that is expanded
+// by StreamObject when it is sent. It returns nil if the object is gone.
+func objectPutMessage(bucket, key, etag string, size int64) (*message.MinioMessage, error) {
+	if size > ChunkSize {
+		return beginMessage(bucket, key, etag, size), nil
+	}
+	obj, err := mClient.GetObject(context.Background(), bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if IsNotFound(err) {
+		// deleted since the event or the listing, a delete event follows
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta, err := sourceMeta(bucket, key, info)
+	if err != nil {
+		return nil, err
+	}
+	cont, err := io.ReadAll(obj)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message.MinioMessage{
+		Type:    message.MessageType_S3_Object_Put,
+		Bucket:  bucket,
+		Name:    key,
+		Etag:    etag,
+		Content: cont,
+		Meta:    meta,
+	}, nil
+}
+
+// createdMessage is objectPutMessage for callers that can't wait for the
+// object. If it can't be read now it is announced with a S3_Object_Put_Begin,
+// which is read again and retried when it is sent.
+func createdMessage(bucket, key, etag string, size int64) *message.MinioMessage {
+	msg, err := objectPutMessage(bucket, key, etag, size)
+	if err != nil {
+		log.Printf("read object bucket(%s) name(%s) failed, stream it later: %v\n", bucket, key, err)
+		return beginMessage(bucket, key, etag, size)
+	}
+	return msg
+}
+
+func beginMessage(bucket, key, etag string, size int64) *message.MinioMessage {
+	return &message.MinioMessage{
+		Type:   message.MessageType_S3_Object_Put_Begin,
+		Bucket: bucket,
+		Name:   key,
+		Etag:   etag,
+		Size:   size,
+	}
+}
+
+// ReadObject reads the object announced by a S3_Object_Put_Begin message into
+// an inline S3_Object_Put message, for peers that can't receive chunks.
+func ReadObject(begin *message.MinioMessage) (*message.MinioMessage, error) {
+	obj, err := mClient.GetObject(context.Background(), begin.GetBucket(), begin.GetName(), minio.GetObjectOptions{})
+	if err != nil {
+		return begin, err
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		return begin, err
+	}
+	meta, err := sourceMeta(begin.GetBucket(), begin.GetName(), info)
+	if err != nil {
+		return begin, err
+	}
+	cont, err := io.ReadAll(obj)
+	if err != nil {
+		return begin, err
+	}
+	return &message.MinioMessage{
+		Seq:     begin.GetSeq(),
+		Session: begin.GetSession(),
+		Type:    message.MessageType_S3_Object_Put,
+		Bucket:  begin.GetBucket(),
+		Name:    begin.GetName(),
+		Etag:    info.ETag,
+		Content: cont,
+		Meta:    meta,
+	}, nil
+}
+
+// StreamObject reads the object announced by a S3_Object_Put_Begin message
+// from minio and streams it to the server. All messages of the stream carry
+// the seq and session of begin.
+//
+// The begin message is passed to resume, which returns the offset the server
+// already has of this object, e.g. from a transfer interrupted by a broken
+// connection. The rest of the object is passed to send as chunkSize sized
+// chunk messages followed by a commit message, an error of send aborts the
+// stream.
+//
+// If the object can't be read anymore (e.g. it was deleted in the meantime)
+// the error is returned before anything is sent.
+func StreamObject(begin *message.MinioMessage, chunkSize int64, resume func(msg *message.MinioMessage) (int64, error), send func(msg *message.MinioMessage) error) error {
+	obj, err := mClient.GetObject(context.Background(), begin.GetBucket(), begin.GetName(), minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		return err
+	}
+	meta, err := sourceMeta(begin.GetBucket(), begin.GetName(), info)
+	if err != nil {
+		return err
+	}
+
+	offset, err := resume(&message.MinioMessage{
+		Seq:     begin.GetSeq(),
+		Session: begin.GetSession(),
+		Type:    message.MessageType_S3_Object_Put_Begin,
+		Bucket:  begin.GetBucket(),
+		Name:    begin.GetName(),
+		Etag:    info.ETag,
+		Size:    info.Size,
+		Meta:    meta,
+	})
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		if _, err := obj.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	for offset < info.Size {
+		buf := make([]byte, min(chunkSize, info.Size-offset))
+		if _, err := io.ReadFull(obj, buf); err != nil {
+			return err
+		}
+		err = send(&message.MinioMessage{
+			Seq:     begin.GetSeq(),
+			Session: begin.GetSession(),
+			Type:    message.MessageType_S3_Object_Put_Chunk,
+			Bucket:  begin.GetBucket(),
+			Name:    begin.GetName(),
+			Offset:  offset,
+			Content: buf,
+		})
+		if err != nil {
+			return err
+		}
+		offset += int64(len(buf))
+	}
+	return send(&message.MinioMessage{
+		Seq:     begin.GetSeq(),
+		Session: begin.GetSession(),
+		Type:    message.MessageType_S3_Object_Put_Commit,
+		Bucket:  begin.GetBucket(),
+		Name:    begin.GetName(),
+		Etag:    info.ETag,
+		Size:    info.Size,
+	})
+}
+
+// IsNotFound reports whether err means the object doesn't exist (anymore).
+func IsNotFound(err error) bool {
+	return err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
+// transfer is an object being streamed into minio on the server.
+//
+// The object is written as a multipart upload, so the parts uploaded so far
+// survive a broken connection and the client can resume after them. Only the
+// part being filled is kept in memory.
+type transfer struct {
+	bucket    string
+	name      string
+	etag      string
+	size      int64
+	meta      *message.ObjectMeta
+	skip      bool // 目标端已经有相同 etag 的对象, 不用再传
+	uploadId  string
+	parts     []minio.CompletePart
+	committed int64  // bytes in uploaded parts, where a resumed transfer starts
+	buf       []byte // part being received
+	bufLock   sync.Mutex
+	last      time.Time // 最后一次收到 chunk 的时间
+}
+
+// transfers are kept across connections, keyed by bucket/name, until they are
+// committed or replaced by a transfer of another version of the object.
+// Uploads never resumed are left to minio's stale multipart upload cleanup,
+// the part being filled is dropped once they are idle for transferIdle.
+//
+// Which version of an object an upload is for is recorded in transferDir, so
+// a restarted server resumes the upload instead of starting over.
+//
+// The lock only guards the map. The messages of an object are applied by one
+// worker, so a transfer is only used by one goroutine besides evictIdle.
+var transfers = struct {
+	lock sync.Mutex
+	m    map[string]*transfer
+}{m: make(map[string]*transfer)}
+
+func transferKey(msg *message.MinioMessage) string {
+	return msg.GetBucket() + "/" + msg.GetName()
+}
+
+func getTransfer(key string) *transfer {
+	transfers.lock.Lock()
+	defer transfers.lock.Unlock()
+	return transfers.m[key]
+}
+
+func putTransfer(key string, t *transfer) {
+	transfers.lock.Lock()
+	defer transfers.lock.Unlock()
+	if t == nil {
+		delete(transfers.m, key)
+		return
+	}
+	transfers.m[key] = t
+}
+
+// transferIdle is how long a transfer receives no chunk before the part being
+// filled is dropped. The client resumes after the uploaded parts anyway.
+const transferIdle = 10 * time.Minute
+
+// evictIdle frees the parts being filled of the transfers the client gave up
+// on, e.g. because the object was deleted on the source.
+func evictIdle() {
+	for range time.Tick(time.Minute) {
+		transfers.lock.Lock()
+		ts := make([]*transfer, 0, len(transfers.m))
+		for _, t := range transfers.m {
+			ts = append(ts, t)
+		}
+		transfers.lock.Unlock()
+		for _, t := range ts {
+			// a transfer busy uploading isn't idle
+			if !t.bufLock.TryLock() {
+				continue
+			}
+			if t.buf != nil && time.Since(t.last) > transferIdle {
+				log.Printf("transfer bucket(%s) name(%s) idle since %s, drop its %d buffered bytes\n", t.bucket, t.name, t.last.Format(time.RFC3339), len(t.buf))
+				// a chunk arriving later doesn't match the offset, the client resumes
+				t.buf = nil
+			}
+			t.bufLock.Unlock()
+		}
+	}
+}
+
+// transferDir holds a transferRecord per open multipart upload.
+var transferDir string
+
+// transferRecord is what a restarted server needs to know of an upload
+// besides its parts, which are listed from minio.
+type transferRecord struct {
+	Bucket   string `json:"bucket"`
+	Name     string `json:"name"`
+	UploadId string `json:"uploadId"`
+	Etag     string `json:"etag"`
+	Size     int64  `json:"size"`
+	Meta     []byte `json:"meta,omitempty"` // 序列化的 ObjectMeta
+}
+
+// InitTransfers sets the directory the open uploads are recorded in.
+func InitTransfers(dir string) error {
+	transferDir = dir
+	go evictIdle()
+	return os.MkdirAll(dir, 0o755)
+}
+
+func recordPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(transferDir, hex.EncodeToString(sum[:]))
+}
+
+func (t *transfer) save() error {
+	meta, err := proto.Marshal(t.meta)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(transferRecord{Bucket: t.bucket, Name: t.name, UploadId: t.uploadId, Etag: t.etag, Size: t.size, Meta: meta})
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(recordPath(t.bucket+"/"+t.name), data)
+}
+
+func (t *transfer) forget() {
+	if err := os.Remove(recordPath(t.bucket + "/" + t.name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("remove transfer record of bucket(%s) name(%s) failed: %v\n", t.bucket, t.name, err)
+	}
+}
+
+// restoreTransfer rebuilds the transfer of msg a previous run of the server
+// left unfinished from the record and the parts of its upload, nil if there
+// is none. Uploads of the object for another version are aborted, the server
+// is the only one writing the target.
+func restoreTransfer(msg *message.MinioMessage) (*transfer, error) {
+	var rec transferRecord
+	data, err := os.ReadFile(recordPath(transferKey(msg)))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var meta *message.ObjectMeta
+	if err == nil {
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, err
+		}
+		if len(rec.Meta) > 0 {
+			// clients not sending metadata have none
+			meta = &message.ObjectMeta{}
+			if err := proto.Unmarshal(rec.Meta, meta); err != nil {
+				return nil, err
+			}
+		}
+	}
+	ctx := context.Background()
+	var restored *transfer
+	keyMarker, uploadMarker := "", ""
+	for {
+		res, err := mCore.ListMultipartUploads(ctx, msg.GetBucket(), msg.GetName(), keyMarker, uploadMarker, "", 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range res.Uploads {
+			if u.Key != msg.GetName() {
+				continue
+			}
+			t := &transfer{bucket: msg.GetBucket(), name: msg.GetName(), uploadId: u.UploadID}
+			if restored == nil && u.UploadID == rec.UploadId && rec.Etag == msg.GetEtag() && rec.Size == msg.GetSize() && proto.Equal(meta, msg.GetMeta()) {
+				t.etag, t.size, t.meta = rec.Etag, rec.Size, msg.GetMeta()
+				if err := t.listParts(); err != nil {
+					return nil, err
+				}
+				restored = t
+				continue
+			}
+			log.Printf("abort stale upload(%s) of bucket(%s) name(%s)\n", u.UploadID, t.bucket, t.name)
+			t.abort()
+		}
+		if !res.IsTruncated {
+			break
+		}
+		keyMarker, uploadMarker = res.NextKeyMarker, res.NextUploadIDMarker
+	}
+	if restored == nil && rec.UploadId != "" {
+		(&transfer{bucket: msg.GetBucket(), name: msg.GetName()}).forget()
+	}
+	return restored, nil
+}
+
+// listParts loads the parts uploaded so far.
+func (t *transfer) listParts() error {
+	marker := 0
+	for {
+		res, err := mCore.ListObjectParts(context.Background(), t.bucket, t.name, t.uploadId, marker, 1000)
+		if err != nil {
+			return err
+		}
+		for _, p := range res.ObjectParts {
+			if p.PartNumber != len(t.parts)+1 {
+				return fmt.Errorf("upload(%s) of %s/%s misses part %d", t.uploadId, t.bucket, t.name, len(t.parts)+1)
+			}
+			t.parts = append(t.parts, minio.CompletePart{PartNumber: p.PartNumber, ETag: p.ETag})
+			t.committed += p.Size
+		}
+		if !res.IsTruncated {
+			return nil
+		}
+		marker = res.NextPartNumberMarker
+	}
+}
+
+// BeginTransfer starts or resumes the transfer announced by a
+// S3_Object_Put_Begin message and returns the offset the client has to
+// continue from.
+func BeginTransfer(msg *message.MinioMessage) (int64, error) {
+	key := transferKey(msg)
+	t := getTransfer(key)
+	if t == nil {
+		var err error
+		if t, err = restoreTransfer(msg); err != nil {
+			return 0, err
+		}
+		if t != nil {
+			putTransfer(key, t)
+		}
+	}
+	if t != nil {
+		if t.etag == msg.GetEtag() && t.size == msg.GetSize() && proto.Equal(t.meta, msg.GetMeta()) {
+			// the part being filled is lost with the connection
+			t.bufLock.Lock()
+			t.buf = nil
+			t.last = time.Now()
+			t.bufLock.Unlock()
+			log.Printf("resume transfer bucket(%s) name(%s) at offset(%d)\n", t.bucket, t.name, t.committed)
+			return t.committed, nil
+		}
+		t.abort()
+		t.forget()
+		putTransfer(key, nil)
+	}
+
+	t = &transfer{bucket: msg.GetBucket(), name: msg.GetName(), etag: msg.GetEtag(), size: msg.GetSize(), meta: msg.GetMeta(), last: time.Now()}
+	obj, err := mClient.StatObject(context.Background(), msg.GetBucket(), msg.GetName(), minio.StatObjectOptions{})
+	// obj exist and accessable
+	if err == nil && sameObject(obj, msg) {
+		if err := syncTags(obj, msg); err != nil {
+			return 0, err
+		}
+		// nothing to send, go straight to the commit
+		t.skip = true
+		t.committed = t.size
+		putTransfer(key, t)
+		return t.committed, nil
+	}
+	t.uploadId, err = mCore.NewMultipartUpload(context.Background(), t.bucket, t.name, putOptions(t.meta, t.etag))
+	if err != nil {
+		return 0, err
+	}
+	if err := t.save(); err != nil {
+		t.abort()
+		return 0, err
+	}
+	putTransfer(key, t)
+	return 0, nil
+}
+
+func writeChunk(msg *message.MinioMessage) error {
+	key := transferKey(msg)
+	t := getTransfer(key)
+	if t == nil {
+		return fmt.Errorf("chunk of %s without transfer", key)
+	}
+	t.bufLock.Lock()
+	defer t.bufLock.Unlock()
+	t.last = time.Now()
+	if written := t.committed + int64(len(t.buf)); msg.GetOffset() != written {
+		return fmt.Errorf("chunk of %s at offset %d, expected %d", key, msg.GetOffset(), written)
+	}
+	t.buf = append(t.buf, msg.GetContent()...)
+	if len(t.buf) < partSize {
+		return nil
+	}
+	return t.uploadPart()
+}
+
+func commitTransfer(msg *message.MinioMessage) error {
+	key := transferKey(msg)
+	t := getTransfer(key)
+	if t == nil {
+		return fmt.Errorf("commit of %s without transfer", key)
+	}
+	t.bufLock.Lock()
+	defer t.bufLock.Unlock()
+	if written := t.committed + int64(len(t.buf)); written != t.size {
+		return fmt.Errorf("commit of %s after %d of %d bytes", key, written, t.size)
+	}
+	if !t.skip {
+		if len(t.buf) > 0 {
+			if err := t.uploadPart(); err != nil {
+				return err
+			}
+		}
+		_, err := mCore.CompleteMultipartUpload(context.Background(), t.bucket, t.name, t.uploadId, t.parts, minio.PutObjectOptions{})
+		if err != nil {
+			return err
+		}
+		t.forget()
+	}
+	putTransfer(key, nil)
+	return nil
+}
+
+// uploadPart uploads the part being filled and starts the next one.
+func (t *transfer) uploadPart() error {
+	part, err := mCore.PutObjectPart(context.Background(), t.bucket, t.name, t.uploadId, len(t.parts)+1,
+		bytes.NewReader(t.buf), int64(len(t.buf)), minio.PutObjectPartOptions{})
+	if err != nil {
+		return err
+	}
+	t.parts = append(t.parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+	t.committed += int64(len(t.buf))
+	t.buf = t.buf[:0]
+	return nil
+}
+
+func (t *transfer) abort() {
+	if t.skip {
+		return
+	}
+	err := mCore.AbortMultipartUpload(context.Background(), t.bucket, t.name, t.uploadId)
+	if err != nil {
+		log.Printf("abort transfer bucket(%s) name(%s) failed: %v\n", t.bucket, t.name, err)
+	}
+}
@@ -0,0 +1,171 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/message"
+)
+
+var ErrAuthFailed = errors.New("authentication failed")
+
+const nonceSize = 32
+
+// The handshake proves that the client knows the pre-shared key before the
+// server accepts any frame, and negotiates the protocol version:
+//
+//	server -> client: Challenge{nonce, server hello}
+//	client -> server: Auth{mac = HMAC-SHA256(key, nonce + client hello), client hello}
+//	server -> client: AuthResult{ok, negotiated hello, error, mac = HMAC-SHA256(key, client mac + result)}
+//
+// The connection is closed if the mac is wrong or the versions are
+// incompatible. All of them are sent as LengthFieldBasedFrameCodec frames
+// without checksum, the macs protect them. The challenge isn't signed, so the
+// client uses the hello it negotiated with the server hello itself and only
+// accepts a signed result negotiating the same.
+
+// NewChallenge returns the challenge the server sends on a new connection.
+func NewChallenge() (*message.Challenge, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &message.Challenge{Nonce: nonce, Hello: LocalHello()}, nil
+}
+
+// Sign answers challenge with the key, announcing hello.
+func Sign(key []byte, challenge *message.Challenge, hello *message.Hello) *message.Auth {
+	return &message.Auth{Mac: mac(key, challenge.GetNonce(), hello), Hello: hello}
+}
+
+// Verify reports whether auth answers challenge with the key.
+func Verify(key []byte, challenge *message.Challenge, auth *message.Auth) bool {
+	return hmac.Equal(mac(key, challenge.GetNonce(), auth.GetHello()), auth.GetMac())
+}
+
+// mac covers the hello too, so it can't be tampered with to downgrade the
+// connection. Fields added later are only covered if they are set, so peers
+// not knowing them compute the same mac.
+func mac(key, nonce []byte, hello *message.Hello) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(nonce)
+	binary.Write(h, binary.BigEndian, hello.GetVersion())
+	binary.Write(h, binary.BigEndian, hello.GetMinVersion())
+	binary.Write(h, binary.BigEndian, hello.GetCapabilities())
+	if hello.GetMaxBodySize() != 0 {
+		binary.Write(h, binary.BigEndian, hello.GetMaxBodySize())
+	}
+	if hello.GetWindowBytes() != 0 {
+		binary.Write(h, binary.BigEndian, hello.GetWindowBytes())
+	}
+	return h.Sum(nil)
+}
+
+// resultMac binds the result to the auth it answers, so it can't be replayed
+// or tampered with to downgrade the connection either.
+func resultMac(key, authMac []byte, result *message.AuthResult) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte("result"))
+	h.Write(authMac)
+	binary.Write(h, binary.BigEndian, result.GetOk())
+	hello := result.GetHello()
+	binary.Write(h, binary.BigEndian, hello.GetVersion())
+	binary.Write(h, binary.BigEndian, hello.GetMinVersion())
+	binary.Write(h, binary.BigEndian, hello.GetCapabilities())
+	binary.Write(h, binary.BigEndian, hello.GetMaxBodySize())
+	binary.Write(h, binary.BigEndian, hello.GetWindowBytes())
+	h.Write([]byte(result.GetError()))
+	return h.Sum(nil)
+}
+
+// ClientHandshake runs the client side of the handshake on a new connection
+// and returns the negotiated hello.
+func ClientHandshake(conn io.ReadWriter, key []byte) (*message.Hello, error) {
+	codec := LengthFieldBasedFrameCodec{}
+	challenge := &message.Challenge{}
+	if err := codec.ReadMessage(conn, challenge); err != nil {
+		return nil, err
+	}
+	local := LocalHello()
+	// fail early, the server would refuse us anyway
+	hello, err := Negotiate(local, challenge.GetHello())
+	if err != nil {
+		return nil, err
+	}
+	auth := Sign(key, challenge, local)
+	if err := codec.WriteMessage(conn, auth); err != nil {
+		return nil, err
+	}
+	result := &message.AuthResult{}
+	if err := codec.ReadMessage(conn, result); err != nil {
+		// the server closes the connection if the key is wrong
+		if errors.Is(err, io.EOF) {
+			return nil, ErrAuthFailed
+		}
+		return nil, err
+	}
+	if !hmac.Equal(resultMac(key, auth.GetMac(), result), result.GetMac()) {
+		return nil, fmt.Errorf("%w: result not signed by the server", ErrAuthFailed)
+	}
+	if !result.GetOk() {
+		return nil, fmt.Errorf("%w: %s", ErrIncompatibleVersion, result.GetError())
+	}
+	// the server negotiated with a challenge hello that was changed on the way
+	if !proto.Equal(hello, result.GetHello()) {
+		return nil, fmt.Errorf("%w: server negotiated %v, expected %v", ErrAuthFailed, result.GetHello(), hello)
+	}
+	return hello, nil
+}
+
+// ServerHandshake checks the client's answer to challenge and returns the
+// result to send back. The error is not nil if the connection has to be
+// closed, the result is nil if the client must not learn why.
+func ServerHandshake(key []byte, challenge *message.Challenge, auth *message.Auth) (*message.AuthResult, error) {
+	if !Verify(key, challenge, auth) {
+		return nil, ErrAuthFailed
+	}
+	result := &message.AuthResult{Ok: true}
+	hello, err := Negotiate(LocalHello(), auth.GetHello())
+	if err != nil {
+		result = &message.AuthResult{Error: err.Error()}
+	} else {
+		result.Hello = hello
+	}
+	result.Mac = resultMac(key, auth.GetMac(), result)
+	return result, err
+}
+
+// EncodeMessage marshals m into a frame.
+func (codec LengthFieldBasedFrameCodec) EncodeMessage(m proto.Message) ([]byte, error) {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Encode(data)
+}
+
+// WriteMessage writes m as a frame to w.
+func (codec LengthFieldBasedFrameCodec) WriteMessage(w io.Writer, m proto.Message) error {
+	packet, err := codec.EncodeMessage(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(packet)
+	return err
+}
+
+// ReadMessage reads a frame from r and unmarshals it into m.
+func (codec LengthFieldBasedFrameCodec) ReadMessage(r io.Reader, m proto.Message) error {
+	data, err := codec.DecodeReader(r)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, m)
+}
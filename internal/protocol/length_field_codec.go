@@ -1,10 +1,11 @@
 package protocol
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"hash/crc32"
+	"io"
 
 	"github.com/panjf2000/gnet/v2"
 )
@@ -14,11 +15,9 @@ var ErrCheckFailPacket = errors.New("check fail packet")
 var ErrInvalidMagicNumber = errors.New("invalid magic number")
 var ErrTooLargeBody = errors.New("body too large")
 
-const ConnectedAck = "server connected\n"
-
 const (
 	bodySizeHexNum = 4
-	bodySize       = 4096
+	bodySize       = 4096 // 握手阶段 body 的最大长度
 )
 
 var magicNumberBytes []byte = []byte{10, 12, 10, 15}
@@ -32,20 +31,42 @@ var magicNumberSize = 4
 // * +-----------+-----------+-----------+
 // * |                                   |
 // * +                                   +
-// * |           body bytes (max 4096)   |
+// * |           body bytes              |
 // * +                                   +
 // * |            ... ...                |
 // * +-----------------------------------+
-type LengthFieldBasedFrameCodec struct{}
+// * |  crc32c   |
+// * +-----------+
+//
+// The CRC32C checksum covers magic, body len and body. It is only present if
+// Checksum is set, i.e. after the handshake negotiated CapChecksum.
+//
+// Bodies larger than MaxBodySize are rejected by both Encode and Decode, the
+// zero value allows the small handshake messages only.
+type LengthFieldBasedFrameCodec struct {
+	Checksum    bool
+	MaxBodySize int
+}
+
+func (codec LengthFieldBasedFrameCodec) maxBodySize() int {
+	if codec.MaxBodySize == 0 {
+		return bodySize
+	}
+	return codec.MaxBodySize
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+const checksumSize = 4
 
 // Encode encodes the provided buffer into a byte slice.
 //
 // The encoded data consists of the following parts:
 //
-// * Magic number (4 bit): A fixed value identifying the protocol.
-// * Body length (12 bit): The length of the body in bytes.
-// * Body (max 4096 bit): The actual data to be sent.
-// * CRC-16 checksum (4 bit): A checksum of the magic number, body length, and body bytes.
+// * Magic number (4 byte): A fixed value identifying the protocol.
+// * Body length (4 byte): The length of the body in bytes.
+// * Body: The actual data to be sent.
+// * CRC32C checksum (4 byte): A checksum of the magic number, body length, and body bytes.
 //
 // Parameter(s):
 //
@@ -55,27 +76,53 @@ type LengthFieldBasedFrameCodec struct{}
 //
 //	[]byte, error: The encoded data and/or an error if something went wrong.
 func (codec LengthFieldBasedFrameCodec) Encode(buf []byte) ([]byte, error) {
-	// if len(buf) > bodySize {
-	// return nil, ErrTooLargeBody
-	// }
+	if len(buf) > codec.maxBodySize() {
+		return nil, ErrTooLargeBody
+	}
 	bodyOffset := magicNumberSize + bodySizeHexNum
 	msgLen := bodyOffset + len(buf)
-	data := make([]byte, msgLen)
+	data := make([]byte, msgLen, msgLen+checksumSize)
 	copy(data, magicNumberBytes)
 
 	bodyLen := IntToBytes(len(buf))
 	copy(data[bodyOffset-len(bodyLen):bodyOffset], []byte(bodyLen))
 	copy(data[bodyOffset:msgLen], buf)
+	if codec.Checksum {
+		data = binary.BigEndian.AppendUint32(data, crc32.Checksum(data, crcTable))
+	}
 	return data, nil
 }
 
+// frameLen returns the length of a frame with a body of bodyLen bytes.
+func (codec LengthFieldBasedFrameCodec) frameLen(bodyLen int) int {
+	n := magicNumberSize + bodySizeHexNum + bodyLen
+	if codec.Checksum {
+		n += checksumSize
+	}
+	return n
+}
+
+// verify checks the checksum at the end of frame.
+func (codec LengthFieldBasedFrameCodec) verify(frame []byte) error {
+	if !codec.Checksum {
+		return nil
+	}
+	n := len(frame) - checksumSize
+	if crc32.Checksum(frame[:n], crcTable) != binary.BigEndian.Uint32(frame[n:]) {
+		return ErrCheckFailPacket
+	}
+	return nil
+}
+
 // Decode decodes the data received from the connection.
 //
 // It reads the first bodyOffset bytes from the connection and verifies
 // that they contain the correct magic number and body length.
 //
-// It then reads the entire message (body length + magic number + CRC-16 checksum)
-// from the connection and discards the magic number and CRC-16 checksum.
+// It then reads the entire message (magic number + body length + body +
+// CRC32C checksum) from the connection, verifies the checksum and returns
+// the body. A frame failing the check is discarded and ErrCheckFailPacket
+// returned.
 //
 // c - gnet.Conn
 // []byte, error
@@ -93,11 +140,14 @@ func (codec *LengthFieldBasedFrameCodec) Decode(c gnet.Conn) ([]byte, error) {
 		return nil, ErrInvalidMagicNumber
 	}
 
-	// Extract the body length
+	// Extract the body length, never buffer more than allowed
 	bodyLen := BytesToInt(buf[magicNumberSize:bodyOffset])
+	if bodyLen < 0 || bodyLen > codec.maxBodySize() {
+		return nil, ErrTooLargeBody
+	}
 
 	// Calculate the full message length
-	msgLen := bodyOffset + bodyLen
+	msgLen := codec.frameLen(bodyLen)
 
 	// Check if the connection contains a complete packet
 	if c.InboundBuffered() < msgLen {
@@ -106,8 +156,11 @@ func (codec *LengthFieldBasedFrameCodec) Decode(c gnet.Conn) ([]byte, error) {
 	// Read the entire message from the connection
 	buf, _ = c.Peek(msgLen)
 	_, _ = c.Discard(msgLen)
+	if err := codec.verify(buf); err != nil {
+		return nil, err
+	}
 
-	// Return the decoded data (without the magic number and CRC-16 checksum)
+	// Return the decoded data (without the magic number and checksum)
 	return buf[bodyOffset : bodyOffset+bodyLen], nil
 }
 
@@ -120,10 +173,10 @@ func (codec *LengthFieldBasedFrameCodec) Decode(c gnet.Conn) ([]byte, error) {
 // error  - an error if the unpacking failed
 func (codec LengthFieldBasedFrameCodec) Unpack(buf []byte) ([]byte, error) {
 	// The minimum length of a valid packet is the size of the magic number (4 bytes)
-	// + the size of the body length field (12 bit)
-	// + the size of the CRC-16 checksum (4 bit)
-	bodyOffset := magicNumberSize + bodySize
-	if len(buf) < bodyOffset {
+	// + the size of the body length field (4 bytes)
+	// + the size of the checksum (4 bytes)
+	bodyOffset := magicNumberSize + bodySizeHexNum
+	if len(buf) < codec.frameLen(0) {
 		return nil, ErrIncompletePacket
 	}
 
@@ -132,56 +185,74 @@ func (codec LengthFieldBasedFrameCodec) Unpack(buf []byte) ([]byte, error) {
 		return nil, ErrInvalidMagicNumber
 	}
 
-	// Extract the body length
-	bodyLen := 4
+	// Extract the body length, never buffer more than allowed
+	bodyLen := BytesToInt(buf[magicNumberSize:bodyOffset])
+	if bodyLen < 0 || bodyLen > codec.maxBodySize() {
+		return nil, ErrTooLargeBody
+	}
 
 	// Calculate the full message length
-	msgLen := bodyOffset + bodyLen
+	msgLen := codec.frameLen(bodyLen)
 
 	// Check if the buf contains a complete packet
 	if len(buf) < msgLen {
 		return nil, ErrIncompletePacket
 	}
 
-	// Check the CRC-16 checksum
-	crc16 := buf[bodyOffset+bodyLen : msgLen]
-	if crc16 == nil {
-		return nil, ErrCheckFailPacket
+	// Check the CRC32C checksum
+	if err := codec.verify(buf[:msgLen]); err != nil {
+		return nil, err
 	}
 
 	// Return the extracted data
 	return buf[bodyOffset : bodyOffset+bodyLen], nil
 }
 
-func (codec *LengthFieldBasedFrameCodec) DecodeReader(r *bufio.Reader) ([]byte, error) {
+// DecodeBuffer takes a frame from the front of buf, see Decode. The returned
+// body is only valid until buf is written to again.
+func (codec LengthFieldBasedFrameCodec) DecodeBuffer(buf *bytes.Buffer) ([]byte, error) {
+	body, err := codec.Unpack(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	buf.Next(codec.frameLen(len(body)))
+	return body, nil
+}
+
+// DecodeReader reads a frame from r, see Decode.
+func (codec *LengthFieldBasedFrameCodec) DecodeReader(r io.Reader) ([]byte, error) {
 	bodyOffset := magicNumberSize + bodySizeHexNum
 	// Read the first bodyOffset bytes from the connection
 	buf := make([]byte, bodyOffset)
-	_, err := r.Read(buf)
+	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(buf) < bodyOffset {
-		// The buffer doesn't contain a complete packet
-		return nil, ErrIncompletePacket
-	}
-
 	// Check the magic number
 	if !bytes.Equal(magicNumberBytes, buf[:magicNumberSize]) {
 		return nil, ErrInvalidMagicNumber
 	}
 
-	// Extract the body length
+	// Extract the body length, never buffer more than allowed
 	bodyLen := BytesToInt(buf[magicNumberSize:bodyOffset])
+	if bodyLen < 0 || bodyLen > codec.maxBodySize() {
+		return nil, ErrTooLargeBody
+	}
 
-	buf = make([]byte, bodyLen)
+	frame := make([]byte, codec.frameLen(bodyLen))
+	copy(frame, buf)
 
 	// Read the entire message from the connection
-	_, _ = r.Read(buf)
+	if _, err := io.ReadFull(r, frame[bodyOffset:]); err != nil {
+		return nil, err
+	}
+	if err := codec.verify(frame); err != nil {
+		return nil, err
+	}
 
-	// Return the decoded data (without the magic number and CRC-16 checksum)
-	return buf, nil
+	// Return the decoded data (without the magic number and checksum)
+	return frame[bodyOffset : bodyOffset+bodyLen], nil
 }
 
 func IntToBytes(n int) []byte {
@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/message"
+)
+
+var ErrIncompatibleVersion = errors.New("incompatible protocol version")
+
+const (
+	Version    = 1 // 当前协议版本
+	MinVersion = 1 // 还能兼容的最老协议版本
+)
+
+// Capability is an optional protocol feature, a connection only uses the
+// capabilities both peers announced in their hello.
+type Capability uint64
+
+const (
+	CapAcks Capability = 1 << iota
+	CapChunking
+	CapCompression
+	CapChecksum
+	CapEncryption
+	CapInventory
+)
+
+// Capabilities are the features this build supports.
+var Capabilities = CapAcks | CapChunking | CapCompression | CapChecksum | CapInventory
+
+// Required are the capabilities a connection must negotiate, e.g.
+// CapEncryption when a key file is configured.
+var Required Capability
+
+// DefaultMaxBodySize is the default of MaxBodySize.
+const DefaultMaxBodySize = 16 << 20
+
+// MaxBodySize is the largest frame body this side accepts, a connection uses
+// the smaller one of both peers.
+var MaxBodySize uint32 = DefaultMaxBodySize
+
+// DefaultWindowBytes is the default of WindowBytes.
+const DefaultWindowBytes = 64 << 20
+
+// WindowBytes bounds the bytes of the messages a client sends ahead of their
+// acks, a connection uses the smaller one of both peers. The server has to
+// buffer them while its workers are busy.
+var WindowBytes uint32 = DefaultWindowBytes
+
+// LocalHello returns the hello announcing this build.
+func LocalHello() *message.Hello {
+	return &message.Hello{
+		Version:      Version,
+		MinVersion:   MinVersion,
+		Capabilities: uint64(Capabilities),
+		MaxBodySize:  MaxBodySize,
+		WindowBytes:  WindowBytes,
+	}
+}
+
+// Negotiate returns the hello of a connection between local and remote: the
+// highest version both of them speak and the capabilities they share.
+func Negotiate(local, remote *message.Hello) (*message.Hello, error) {
+	version := min(local.GetVersion(), remote.GetVersion())
+	if version < local.GetMinVersion() || version < remote.GetMinVersion() {
+		return nil, fmt.Errorf("%w: local speaks %d to %d, peer %d to %d", ErrIncompatibleVersion,
+			local.GetMinVersion(), local.GetVersion(), remote.GetMinVersion(), remote.GetVersion())
+	}
+	capabilities := local.GetCapabilities() & remote.GetCapabilities()
+	if missing := Required &^ Capability(capabilities); missing != 0 {
+		return nil, fmt.Errorf("%w: required capabilities(%b) not supported by both peers", ErrIncompatibleVersion, missing)
+	}
+	return &message.Hello{
+		Version:      version,
+		MinVersion:   version,
+		Capabilities: capabilities,
+		MaxBodySize:  minLimit(local.GetMaxBodySize(), remote.GetMaxBodySize()),
+		WindowBytes:  minLimit(local.GetWindowBytes(), remote.GetWindowBytes()),
+	}, nil
+}
+
+// minLimit returns the smaller limit, 0 means the peer doesn't limit it.
+func minLimit(a, b uint32) uint32 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// Has reports whether the negotiated hello includes capability c.
+func Has(hello *message.Hello, c Capability) bool {
+	return Capability(hello.GetCapabilities())&c == c
+}
+
+// Codec returns the frame codec used after the handshake negotiated hello.
+func Codec(hello *message.Hello) LengthFieldBasedFrameCodec {
+	return LengthFieldBasedFrameCodec{
+		Checksum:    Has(hello, CapChecksum),
+		MaxBodySize: int(hello.GetMaxBodySize()),
+	}
+}
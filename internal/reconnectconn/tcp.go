@@ -1,88 +1,213 @@
 package reconnectconn
 
 import (
+	"crypto/tls"
 	"fmt"
+	"log"
+	"math/rand"
 	"net"
 	"sync"
 	"time"
 )
 
+// State is the state of the underlying connection.
+type State int
+
+const (
+	Disconnected State = iota
+	Connected
+)
+
+func (s State) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Backoff configures the delay between two dial attempts.
+type Backoff struct {
+	Min    time.Duration // 第一次重试前的等待时间
+	Max    time.Duration // 等待时间的上限
+	Factor float64       // 每次失败后等待时间乘以 Factor
+	Jitter float64       // 等待时间随机增减的比例, 0 到 1
+}
+
+// Delay returns how long to wait after the given number of failed attempts.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := float64(b.Min)
+	for i := 1; i < attempt && d < float64(b.Max); i++ {
+		d *= b.Factor
+	}
+	d = min(d, float64(b.Max))
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// Conn is a tcp connection that is dialed on first use and dialed again,
+// with backoff, when a read or write on it failed.
 type Conn struct {
-	addr          string        // 如果不传的话 必须要用SetConn把net.Conn设置进来
-	rwTimeout     time.Duration // ReadFull, WriteAll 和 WriteAndReadResp 用到的timeout, dial的timeout也是这个 凑合一下
-	connected     bool
-	retryTimes    int
-	retryInterval time.Duration
-	mutex         sync.Mutex // 所有rw操作都会有锁
-	conn          net.Conn
-	errFunc       func(error)
+	addr      string        // 如果不传的话 必须要用SetConn把net.Conn设置进来
+	rwTimeout time.Duration // ReadFull, WriteAll 和 WriteAndReadResp 用到的timeout, dial的timeout也是这个 凑合一下
+	connected bool
+	closed    bool
+	backoff   Backoff
+	mutex     sync.Mutex // 保护连接的建立
+	conn      net.Conn
+	tlsConfig *tls.Config // nil 时不加密
+	handshake func(net.Conn) error
+	stateFunc func(State)
 }
 
-func New(addr string, timeOut time.Duration, retryTimes int, retryInterval time.Duration, errFunc func(error)) *Conn {
+// New returns a Conn to addr, using tls if tlsConfig is not nil.
+//
+// handshake, if not nil, runs on every new connection before it is used and
+// a failed handshake counts as a failed dial. stateFunc, if not nil, is
+// called every time the connection is established or lost. It is called with
+// the Conn locked and must not use the Conn.
+func New(addr string, timeOut time.Duration, backoff Backoff, tlsConfig *tls.Config, handshake func(net.Conn) error, stateFunc func(State)) *Conn {
 	return &Conn{
-		addr:          addr,
-		rwTimeout:     timeOut,
-		retryTimes:    retryTimes,
-		retryInterval: retryInterval,
-		errFunc:       errFunc,
+		addr:      addr,
+		rwTimeout: timeOut,
+		backoff:   backoff,
+		tlsConfig: tlsConfig,
+		handshake: handshake,
+		stateFunc: stateFunc,
 	}
 }
 
 func (c *Conn) Write(bs []byte) (n int, err error) {
-	err = c.wrapRW(func() error {
-		n, err = c.conn.Write(bs)
+	err = c.wrapRW(func(conn net.Conn) error {
+		n, err = conn.Write(bs)
 		return err
 	})
 	return
 }
 func (c *Conn) Read(buffer []byte) (n int, err error) {
-	err = c.wrapRW(func() error {
-		n, err = c.conn.Read(buffer)
+	err = c.wrapRW(func(conn net.Conn) error {
+		n, err = conn.Read(buffer)
 		return err
 	})
 	return n, err
 }
-func (c *Conn) wrapRW(rw func() error) (err error) {
+
+// Connect establishes the connection if there is none yet.
+func (c *Conn) Connect() error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	if err = c.prepare(); err != nil {
+	return c.prepare()
+}
+
+// Break drops the current connection because of err, e.g. the peer sent data
+// that can't be decoded, the next read or write dials a new one.
+func (c *Conn) Break(err error) {
+	c.mutex.Lock()
+	conn := c.conn
+	c.mutex.Unlock()
+	if conn != nil {
+		c.closeForError(conn, err)
+	}
+}
+
+// wrapRW 只在建立连接时持锁, 读写本身不持锁, 这样阻塞的 Read 不会卡住 Write
+func (c *Conn) wrapRW(rw func(conn net.Conn) error) (err error) {
+	c.mutex.Lock()
+	err = c.prepare()
+	conn := c.conn
+	c.mutex.Unlock()
+	if err != nil {
 		return err
 	}
-	defer func() {
-		c.closeForError(err)
-	}()
-	if err = rw(); err != nil {
+	if err = rw(conn); err != nil {
+		c.closeForError(conn, err)
 		return err
 	}
 	return nil
 }
+
+// prepare dials until the connection is established or the Conn is closed.
 func (c *Conn) prepare() error {
+	if c.closed {
+		return net.ErrClosed
+	}
 	if c.connected {
 		return nil
 	}
 	if c.addr == "" {
 		return fmt.Errorf("no server addr. wait for SetConn call")
 	}
-	i := 0
-	var conn net.Conn
-	var err error
-	for i < c.retryTimes {
-		i++
-		conn, err = net.DialTimeout("tcp", c.addr, c.rwTimeout)
+	for attempt := 1; ; attempt++ {
+		conn, err := c.dial()
 		if err == nil {
 			c.connected = true
 			c.conn = conn
+			c.notify(Connected)
+			return nil
+		}
+		d := c.backoff.Delay(attempt)
+		log.Printf("dial %s failed: %v, retry in %s\n", c.addr, err, d)
+		// 等待时放开锁, 让 Close 可以打断重连
+		c.mutex.Unlock()
+		time.Sleep(d)
+		c.mutex.Lock()
+		if c.closed {
+			return net.ErrClosed
+		}
+		if c.connected {
 			return nil
 		}
-		time.Sleep(c.retryInterval)
 	}
-	return err
 }
 
-func (c *Conn) closeForError(err error) {
-	c.errFunc(err)
+func (c *Conn) dial() (conn net.Conn, err error) {
+	if c.tlsConfig == nil {
+		conn, err = net.DialTimeout("tcp", c.addr, c.rwTimeout)
+	} else {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: c.rwTimeout}, "tcp", c.addr, c.tlsConfig)
+	}
+	if err != nil || c.handshake == nil {
+		return conn, err
+	}
+	conn.SetDeadline(time.Now().Add(c.rwTimeout))
+	if err = c.handshake(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// closeForError drops conn after a failed read or write, the next call dials
+// a new connection. Errors of a connection already replaced are ignored.
+func (c *Conn) closeForError(conn net.Conn, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.connected || c.conn != conn {
+		return
+	}
+	log.Printf("connection to %s broken: %v\n", c.addr, err)
+	conn.Close()
+	c.connected = false
+	c.notify(Disconnected)
+}
+
+func (c *Conn) notify(s State) {
+	if c.stateFunc != nil {
+		c.stateFunc(s)
+	}
 }
 
 func (c *Conn) Close() {
-	c.conn.Close()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	c.connected = false
+	if c.conn != nil {
+		c.conn.Close()
+	}
 }
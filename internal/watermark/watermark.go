@@ -0,0 +1,52 @@
+package watermark
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yimiaoxiehou/minio-sync/internal/atomicfile"
+)
+
+// Watermark records up to when the client captured every change of the
+// source, so after downtime only the objects modified since are sent again.
+type Watermark struct {
+	path string
+	lock sync.Mutex
+	t    time.Time
+}
+
+// Open loads the watermark at path, a zero one if there is none.
+func Open(path string) (*Watermark, error) {
+	w := &Watermark{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if w.t, err = time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data))); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Time returns the watermark, zero if none was saved yet.
+func (w *Watermark) Time() time.Time {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.t
+}
+
+// Save moves the watermark to t.
+func (w *Watermark) Save(t time.Time) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := atomicfile.Write(w.path, []byte(t.UTC().Format(time.RFC3339Nano)+"\n")); err != nil {
+		return err
+	}
+	w.t = t
+	return nil
+}
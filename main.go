@@ -1,17 +1,28 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	c "github.com/yimiaoxiehou/minio-sync/cmd"
+	"github.com/yimiaoxiehou/minio-sync/internal/compression"
+	"github.com/yimiaoxiehou/minio-sync/internal/envelope"
 	"github.com/yimiaoxiehou/minio-sync/internal/minio"
+	"github.com/yimiaoxiehou/minio-sync/internal/protocol"
+	rconn "github.com/yimiaoxiehou/minio-sync/internal/reconnectconn"
+	"github.com/yimiaoxiehou/minio-sync/internal/tlsconfig"
 )
 
+// minFrameSize keeps room for object chunks of a reasonable size.
+const minFrameSize = 64 << 10
+
 type MyFlagSet struct {
 	*flag.FlagSet
 	cmdComment string // 子命令的注释
@@ -25,6 +36,32 @@ func main() {
 		minioPassword string
 		skipBuckets   string
 		appendOnly    string
+		journalDir    string
+		backoffMin    string
+		backoffMax    string
+		backoffJitter string
+		useTLS        string
+		tlsCert       string
+		tlsKey        string
+		tlsCA         string
+		tlsServerName string
+		pskFile       string
+		maxFrameSize  string
+		compressLevel string
+		keyFile       string
+		dataDir       string
+		window        string
+		workers       string
+		readers       string
+		bucketReaders string
+		restartSync   string
+		diffSync      string
+		mirror        string
+		mirrorShare   string
+		mirrorDryRun  string
+		catchUp       string
+		windowBytes   string
+		insecure      string
 	)
 
 	serverCmd := &MyFlagSet{
@@ -39,6 +76,17 @@ func main() {
 	serverCmd.StringVar(&minioUsername, "u", "minio", "minio username\t\t")
 	serverCmd.StringVar(&minioPassword, "password", "minio", "minio password\t\t")
 	serverCmd.StringVar(&minioPassword, "p", "minio", "minio password\t\t")
+	serverCmd.StringVar(&useTLS, "tls", "false", "listen with tls\t\t")
+	serverCmd.StringVar(&tlsCert, "tlsCert", "", "tls certificate file\t")
+	serverCmd.StringVar(&tlsKey, "tlsKey", "", "tls key file\t\t")
+	serverCmd.StringVar(&tlsCA, "tlsCA", "", "CA file, require client certificates signed by it\t")
+	serverCmd.StringVar(&pskFile, "pskFile", "", "pre-shared key file clients authenticate with\t")
+	serverCmd.StringVar(&maxFrameSize, "maxFrameSize", strconv.Itoa(protocol.DefaultMaxBodySize), "max frame size in bytes\t")
+	serverCmd.StringVar(&windowBytes, "windowBytes", strconv.Itoa(protocol.DefaultWindowBytes), "max bytes a client sends ahead of the acks\t")
+	serverCmd.StringVar(&insecure, "insecure", "false", "accept clients without a pre-shared key file\t")
+	serverCmd.StringVar(&keyFile, "keyFile", "", "payload encryption key file, require clients to encrypt\t")
+	serverCmd.StringVar(&workers, "workers", strconv.Itoa(runtime.NumCPU()), "goroutines applying messages\t")
+	serverCmd.StringVar(&dataDir, "dataDir", "data", "directory of the applied messages per client session\t")
 
 	clientCmd := &MyFlagSet{
 		FlagSet:    flag.NewFlagSet("client", flag.ExitOnError),
@@ -54,6 +102,30 @@ func main() {
 	clientCmd.StringVar(&minioPassword, "p", "minio", "minio password\t\t")
 	clientCmd.StringVar(&skipBuckets, "skipBuckets", "false", "skip buckets\t\t")
 	clientCmd.StringVar(&appendOnly, "appendonly", "false", "just sync change\t\t")
+	clientCmd.StringVar(&readers, "readers", "8", "objects read in parallel by the full sync\t")
+	clientCmd.StringVar(&bucketReaders, "bucketReaders", "4", "objects of the same bucket read in parallel by the full sync\t")
+	clientCmd.StringVar(&restartSync, "restartFullSync", "false", "ignore the checkpoint of an unfinished full sync\t")
+	clientCmd.StringVar(&restartSync, "restart-full-sync", "false", "ignore the checkpoint of an unfinished full sync\t")
+	clientCmd.StringVar(&diffSync, "diffSync", "false", "full sync only sends objects the target lacks or has with another etag\t")
+	clientCmd.StringVar(&mirror, "mirror", "false", "full sync deletes the objects only the target has\t")
+	clientCmd.StringVar(&mirrorShare, "mirrorMaxShare", "0.1", "max share of a bucket's target objects mirror deletes, 0 to 1\t")
+	clientCmd.StringVar(&mirrorDryRun, "mirrorDryRun", "false", "mirror only logs the objects it would delete\t")
+	clientCmd.StringVar(&catchUp, "catchUpMargin", "5m", "appendonly start also sends objects modified this long before the last captured change\t")
+	clientCmd.StringVar(&journalDir, "journal", "journal", "journal directory of unsynced messages\t")
+	clientCmd.StringVar(&window, "window", "64", "max messages waiting for an ack\t")
+	clientCmd.StringVar(&windowBytes, "windowBytes", strconv.Itoa(protocol.DefaultWindowBytes), "max bytes of the messages waiting for an ack\t")
+	clientCmd.StringVar(&backoffMin, "reconnectMin", "1s", "first reconnect delay\t")
+	clientCmd.StringVar(&backoffMax, "reconnectMax", "1m", "max reconnect delay\t")
+	clientCmd.StringVar(&backoffJitter, "reconnectJitter", "0.2", "reconnect delay jitter, 0 to 1\t")
+	clientCmd.StringVar(&useTLS, "tls", "false", "connect with tls\t\t")
+	clientCmd.StringVar(&tlsCert, "tlsCert", "", "tls client certificate file\t")
+	clientCmd.StringVar(&tlsKey, "tlsKey", "", "tls client key file\t")
+	clientCmd.StringVar(&tlsCA, "tlsCA", "", "CA file to verify the server, system CAs if empty\t")
+	clientCmd.StringVar(&tlsServerName, "tlsServerName", "", "server name to verify, host of connect address if empty\t")
+	clientCmd.StringVar(&pskFile, "pskFile", "", "pre-shared key file to authenticate with\t")
+	clientCmd.StringVar(&maxFrameSize, "maxFrameSize", strconv.Itoa(protocol.DefaultMaxBodySize), "max frame size in bytes\t")
+	clientCmd.StringVar(&compressLevel, "compressLevel", strconv.Itoa(compression.DefaultLevel), "zstd level 1 to 22, 0 disables compression\t")
+	clientCmd.StringVar(&keyFile, "keyFile", "", "payload encryption key file, first key encrypts\t")
 
 	subCmds := map[string]*MyFlagSet{"server": serverCmd, "client": clientCmd}
 
@@ -101,12 +173,145 @@ func main() {
 		if a := os.Getenv("SKIP_BUCKETS"); a != "" {
 			skipBuckets = a
 		}
+		if a := os.Getenv("READERS"); a != "" {
+			readers = a
+		}
+		if a := os.Getenv("BUCKET_READERS"); a != "" {
+			bucketReaders = a
+		}
+		if a := os.Getenv("RESTART_FULL_SYNC"); a != "" {
+			restartSync = a
+		}
+		if a := os.Getenv("DIFF_SYNC"); a != "" {
+			diffSync = a
+		}
+		if a := os.Getenv("MIRROR"); a != "" {
+			mirror = a
+		}
+		if a := os.Getenv("MIRROR_MAX_SHARE"); a != "" {
+			mirrorShare = a
+		}
+		if a := os.Getenv("MIRROR_DRY_RUN"); a != "" {
+			mirrorDryRun = a
+		}
+		if a := os.Getenv("CATCH_UP_MARGIN"); a != "" {
+			catchUp = a
+		}
+		if a := os.Getenv("JOURNAL_DIR"); a != "" {
+			journalDir = a
+		}
+		if a := os.Getenv("WINDOW"); a != "" {
+			window = a
+		}
+		if a := os.Getenv("WINDOW_BYTES"); a != "" {
+			windowBytes = a
+		}
+		if a := os.Getenv("RECONNECT_MIN"); a != "" {
+			backoffMin = a
+		}
+		if a := os.Getenv("RECONNECT_MAX"); a != "" {
+			backoffMax = a
+		}
+		if a := os.Getenv("RECONNECT_JITTER"); a != "" {
+			backoffJitter = a
+		}
+		if a := os.Getenv("TLS"); a != "" {
+			useTLS = a
+		}
+		if a := os.Getenv("TLS_CERT"); a != "" {
+			tlsCert = a
+		}
+		if a := os.Getenv("TLS_KEY"); a != "" {
+			tlsKey = a
+		}
+		if a := os.Getenv("TLS_CA"); a != "" {
+			tlsCA = a
+		}
+		if a := os.Getenv("TLS_SERVER_NAME"); a != "" {
+			tlsServerName = a
+		}
+		if a := os.Getenv("PSK_FILE"); a != "" {
+			pskFile = a
+		}
+		if a := os.Getenv("MAX_FRAME_SIZE"); a != "" {
+			maxFrameSize = a
+		}
+		if a := os.Getenv("COMPRESS_LEVEL"); a != "" {
+			compressLevel = a
+		}
+		if a := os.Getenv("INSECURE"); a != "" {
+			insecure = a
+		}
+		if a := os.Getenv("KEY_FILE"); a != "" {
+			keyFile = a
+		}
+		if a := os.Getenv("WORKERS"); a != "" {
+			workers = a
+		}
+		if a := os.Getenv("DATA_DIR"); a != "" {
+			dataDir = a
+		}
+	}
+
+	tlsOn, err := strconv.ParseBool(useTLS)
+	if err != nil {
+		log.Fatalln("args tls parse error. mush be bool value")
+	}
+
+	mfs, err := strconv.ParseUint(maxFrameSize, 10, 32)
+	if err != nil || mfs < minFrameSize {
+		log.Fatalf("args maxFrameSize parse error. mush be at least %d bytes\n", minFrameSize)
+	}
+	protocol.MaxBodySize = uint32(mfs)
+
+	wb, err := strconv.ParseUint(windowBytes, 10, 32)
+	if err != nil || wb < 1 {
+		log.Fatalln("args windowBytes parse error. mush be positive int value")
+	}
+	protocol.WindowBytes = uint32(wb)
+
+	var psk []byte
+	if pskFile != "" {
+		data, err := os.ReadFile(pskFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		psk = bytes.TrimSpace(data)
+	} else {
+		log.Println("no pre-shared key, the handshake doesn't authenticate anything")
+	}
+
+	var keyring *envelope.Keyring
+	if keyFile != "" {
+		if keyring, err = envelope.Load(keyFile); err != nil {
+			log.Fatalln(err)
+		}
+		protocol.Capabilities |= protocol.CapEncryption
+		protocol.Required |= protocol.CapEncryption
 	}
 
 	switch cmd.Name() {
 	case "server":
+		in, err := strconv.ParseBool(insecure)
+		if err != nil {
+			log.Fatalln("args insecure parse error. mush be bool value")
+		}
+		if len(psk) == 0 && !in {
+			// anyone could overwrite the target's IAM and objects
+			log.Fatalln("no pre-shared key, set pskFile or insecure")
+		}
 		minio.InitMinioClient(minioAddress, minioUsername, minioPassword)
-		c.RunServer(addr)
+		n, err := strconv.Atoi(workers)
+		if err != nil || n < 1 {
+			log.Fatalln("args workers parse error. mush be positive int value")
+		}
+		opts := c.ServerOptions{Addr: addr, Key: psk, Keyring: keyring, DataDir: dataDir, Workers: n}
+		if tlsOn {
+			if opts.TLS, err = tlsconfig.Server(tlsCert, tlsKey, tlsCA); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		c.RunServer(opts)
 
 	case "client":
 		minio.InitMinioClient(minioAddress, minioUsername, minioPassword)
@@ -114,7 +319,80 @@ func main() {
 		if err != nil {
 			log.Fatalln("args appendOnly parse error. mush be bool value")
 		}
-		c.RunClient(addr, strings.Split(skipBuckets, ","), ao)
+		rs, err := strconv.ParseBool(restartSync)
+		if err != nil {
+			log.Fatalln("args restartFullSync parse error. mush be bool value")
+		}
+		ds, err := strconv.ParseBool(diffSync)
+		if err != nil {
+			log.Fatalln("args diffSync parse error. mush be bool value")
+		}
+		var mo *minio.MirrorOptions
+		if mr, err := strconv.ParseBool(mirror); err != nil {
+			log.Fatalln("args mirror parse error. mush be bool value")
+		} else if mr {
+			mo = &minio.MirrorOptions{}
+			if mo.MaxShare, err = strconv.ParseFloat(mirrorShare, 64); err != nil || mo.MaxShare < 0 || mo.MaxShare > 1 {
+				log.Fatalln("args mirrorMaxShare parse error. mush be float value between 0 and 1")
+			}
+			if mo.DryRun, err = strconv.ParseBool(mirrorDryRun); err != nil {
+				log.Fatalln("args mirrorDryRun parse error. mush be bool value")
+			}
+		}
+		rd, err := strconv.Atoi(readers)
+		if err != nil || rd < 1 {
+			log.Fatalln("args readers parse error. mush be positive int value")
+		}
+		brd, err := strconv.Atoi(bucketReaders)
+		if err != nil || brd < 1 {
+			log.Fatalln("args bucketReaders parse error. mush be positive int value")
+		}
+		win, err := strconv.Atoi(window)
+		if err != nil || win < 1 {
+			log.Fatalln("args window parse error. mush be positive int value")
+		}
+		backoff := rconn.Backoff{Factor: 2}
+		if backoff.Min, err = time.ParseDuration(backoffMin); err != nil {
+			log.Fatalln("args reconnectMin parse error. mush be duration value")
+		}
+		if backoff.Max, err = time.ParseDuration(backoffMax); err != nil {
+			log.Fatalln("args reconnectMax parse error. mush be duration value")
+		}
+		if backoff.Jitter, err = strconv.ParseFloat(backoffJitter, 64); err != nil || backoff.Jitter < 0 || backoff.Jitter > 1 {
+			log.Fatalln("args reconnectJitter parse error. mush be float value between 0 and 1")
+		}
+		margin, err := time.ParseDuration(catchUp)
+		if err != nil || margin < 0 {
+			log.Fatalln("args catchUpMargin parse error. mush be duration value")
+		}
+		level, err := strconv.Atoi(compressLevel)
+		if err != nil || level < 0 || level > 22 {
+			log.Fatalln("args compressLevel parse error. mush be int value between 0 and 22")
+		}
+		compression.SetLevel(level)
+		opts := c.ClientOptions{
+			Addr:            addr,
+			SkipBuckets:     strings.Split(skipBuckets, ","),
+			AppendOnly:      ao,
+			Readers:         rd,
+			BucketReaders:   brd,
+			RestartFullSync: rs,
+			DiffSync:        ds,
+			Mirror:          mo,
+			JournalDir:      journalDir,
+			CatchUpMargin:   margin,
+			Window:          win,
+			WindowBytes:     int(wb),
+			Backoff:         backoff,
+			Key:             psk,
+			Keyring:         keyring,
+		}
+		if tlsOn {
+			if opts.TLS, err = tlsconfig.Client(tlsCert, tlsKey, tlsCA, tlsServerName); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		c.RunClient(opts)
 	default:
 		log.Println("Not support cmd.")
 		os.Exit(2)